@@ -0,0 +1,112 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/fatih/color"
+	"github.com/hashicorp/hil"
+	"github.com/hashicorp/hil/ast"
+	"github.com/spf13/cobra"
+)
+
+var hilVars []string
+
+func init() {
+	hilCmd.Flags().StringArrayVarP(&hilVars, "var", "a", nil, "a name=value string variable to evaluate against, may be repeated")
+	RootCmd.AddCommand(hilCmd)
+}
+
+var hilCmd = &cobra.Command{
+	Use:   "hil <expr>",
+	Short: "hil evaluates an interpolation expression to try it out",
+	Long: `hil evaluates an expression the way ${...} in recipe commands is evaluated, along with
+the same functions. The expression may be given bare like 'upper("web")' or as a template
+like 'deploy ${lower(name)}', which shell quotes the values like a recipe command does. Variables can be bound as strings with --var name=value.
+
+Functions: ` + strings.Join(funcNames(), ", "),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			usageFatal(color.RedString("ERROR")+": ", "blade hil takes exactly one expression")
+		}
+
+		// A template is shell quoted just like a recipe command, a bare expression shows its plain value.
+		expr, template := args[0], strings.Contains(args[0], "${")
+		if !template {
+			expr = "${" + expr + "}"
+		}
+
+		vars := make(map[string]ast.Variable)
+		for _, v := range hilVars {
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) != 2 {
+				usageFatal(color.RedString("ERROR")+": ", fmt.Sprintf("--var %q should look like name=value", v))
+			}
+			vars[parts[0]] = ast.Variable{Type: ast.TypeString, Value: parts[1]}
+		}
+
+		tree, err := hil.Parse(expr)
+		if err != nil {
+			usageFatal(color.RedString("ERROR")+": ", err.Error())
+		}
+		if template {
+			tree = recipe.QuoteInterpolations(tree, nil)
+		}
+		result, err := hil.Eval(tree, recipe.NewEvalConfig(vars))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, color.RedString("ERROR")+": "+err.Error())
+			os.Exit(1)
+		}
+
+		switch value := result.Value.(type) {
+		case []interface{}:
+			for _, item := range value {
+				fmt.Println(item)
+			}
+		case map[string]interface{}:
+			var keys []string
+			for k := range value {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Printf("%s = %v\n", k, value[k])
+			}
+		default:
+			fmt.Println(value)
+		}
+	},
+}
+
+func funcNames() []string {
+	var names []string
+	for name := range recipe.Funcs() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,131 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/deckarep/blade/lib/recipe"
+	bladessh "github.com/deckarep/blade/lib/ssh"
+	"github.com/fatih/color"
+	isatty "github.com/mattn/go-isatty"
+)
+
+var promptColors = map[string]color.Attribute{
+	"red":     color.FgRed,
+	"yellow":  color.FgYellow,
+	"green":   color.FgGreen,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+}
+
+// isInteractive reports whether somebody is at a terminal to answer prompts.
+func isInteractive() bool {
+	fd := os.Stdin.Fd()
+	return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+}
+
+// approvePlan shows the interaction banner of a recipe along with what it is about to do
+// and asks for confirmation when the recipe wants it. --yes approves without asking but
+// without it a recipe that prompts refuses to run when nobody is at a terminal.
+func approvePlan(plan *bladessh.Plan) bool {
+	interaction := plan.Interaction
+	if interaction == nil || (interaction.Banner == "" && !interaction.Prompts()) {
+		return true
+	}
+
+	attribute, ok := promptColors[strings.ToLower(interaction.PromptColor)]
+	if !ok {
+		if interaction.PromptColor != "" {
+			log.Printf("%s: Unknown promptcolor %q, using red", color.YellowString("WARN"), interaction.PromptColor)
+		}
+		attribute = color.FgRed
+	}
+	banner := color.New(attribute, color.Bold)
+
+	fmt.Fprintln(os.Stderr)
+	if interaction.Banner != "" {
+		banner.Fprintln(os.Stderr, interaction.Banner)
+	}
+	banner.Fprintf(os.Stderr, "Recipe %s will run on %d hosts:\n", plan.Recipe, plan.HostCount())
+	describeCommands(plan, "  ")
+	fmt.Fprintln(os.Stderr)
+
+	if !interaction.Prompts() {
+		return true
+	}
+	if assumeYes {
+		log.Print("Continuing since --yes was given")
+		return true
+	}
+	if !isInteractive() {
+		log.Printf("%s: Recipe %s asks for confirmation but there is no terminal to ask on, use --yes to run it unattended",
+			color.RedString("ERROR"), plan.Recipe)
+		return false
+	}
+
+	if interaction.ConfirmText != "" {
+		typed := promptLine(banner.Sprintf("Type %q to continue: ", interaction.ConfirmText))
+		return typed == interaction.ConfirmText
+	}
+	return confirmPrompt(banner.Sprint("Continue?"))
+}
+
+// describeCommands lists the commands of the plan by step and by stage.
+func describeCommands(plan *bladessh.Plan, indent string) {
+	describeLocal(plan.LocalBefore, "local_before", indent)
+	for i, stage := range plan.Stages {
+		fmt.Fprintf(os.Stderr, "%sStage %d: %s on %d hosts\n", indent, i+1, stage.Recipe, stage.HostCount())
+		describeCommands(stage, indent+"  ")
+	}
+	for _, step := range plan.Steps {
+		stepIndent := indent
+		if step.Name != "" {
+			fmt.Fprintf(os.Stderr, "%sStep %s:\n", indent, step.Name)
+			stepIndent += "  "
+		}
+		for _, c := range step.Commands {
+			if c.VariesByHost() {
+				fmt.Fprintf(os.Stderr, "%s%s (varies by host)\n", stepIndent, recipe.Redact(c.Command))
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s%s\n", stepIndent, recipe.Redact(c.Command))
+		}
+	}
+	describeLocal(plan.LocalAfter, "local_after", indent)
+}
+
+func describeLocal(commands []*bladessh.PlannedCommand, section, indent string) {
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "%s%s (%s)\n", indent, recipe.Redact(c.Command), section)
+	}
+}
+
+// confirmAll stands in for confirmPrompt when --yes was given.
+func confirmAll(message string) bool {
+	log.Printf("%s yes (--yes)", message)
+	return true
+}
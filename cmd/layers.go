@@ -0,0 +1,151 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/fatih/color"
+)
+
+// systemRecipesFolder holds the recipes installed for every user of a machine.
+const systemRecipesFolder = "/etc/blade/recipes"
+
+type layerFolder struct {
+	name   string
+	folder string
+}
+
+// recipeLayerFolders are the folders recipes are loaded from, from the lowest to the highest
+// precedence: the system's recipes, the team's recipes in ./recipes and the user's recipes.
+func recipeLayerFolders() []layerFolder {
+	return []layerFolder{
+		{name: "system", folder: systemRecipesFolder},
+		{name: "team", folder: bladeRecipesFolder},
+		{name: "user", folder: path.Join(userHomeDir(), bladeRecipesFolder)},
+	}
+}
+
+// findRecipeLayers groups the recipe files of every layer folder by the dotted name of the
+// recipe they make up, each from the lowest to the highest layer. The folder files of the
+// folders a recipe is in come first, from the farthest to the nearest folder.
+func findRecipeLayers() ([]string, map[string][]*recipe.Layer) {
+	layers := make(map[string][]*recipe.Layer)
+	dirs := make(map[string]string)
+	for _, lf := range recipeLayerFolders() {
+		for _, file := range searchFolders(lf.folder) {
+			if recipe.IsFolderFile(file) {
+				continue
+			}
+			name := recipeName(file)
+			layers[name] = append(layers[name], &recipe.Layer{Name: lf.name, File: file})
+			dirs[name] = path.Dir(path.Join(recipeParts(file)...))
+		}
+	}
+
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+		layers[name] = append(folderLayers(dirs[name]), layers[name]...)
+	}
+	sort.Strings(names)
+	return names, layers
+}
+
+// folderLayers returns the folder files of the folders from the recipes folder down to dir,
+// which is relative to it, in every layer folder.
+func folderLayers(dir string) []*recipe.Layer {
+	dirs := []string{"."}
+	if dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			dirs = append(dirs, path.Join(parts[:i+1]...))
+		}
+	}
+
+	var layers []*recipe.Layer
+	for _, d := range dirs {
+		for _, lf := range recipeLayerFolders() {
+			file := path.Join(lf.folder, d, recipe.FolderFile)
+			if _, err := os.Stat(file); err != nil {
+				continue
+			}
+			name := lf.name + " folder"
+			if d != "." {
+				name += " " + d
+			}
+			layers = append(layers, &recipe.Layer{Name: name, File: file, Folder: true})
+		}
+	}
+	return layers
+}
+
+// loadFolders loads the folder files of every layer folder keyed by their folder relative to
+// the recipes folder, where a higher layer replaces a lower one.
+func loadFolders() map[string]*recipe.BladeFolderYaml {
+	folders := make(map[string]*recipe.BladeFolderYaml)
+	for _, lf := range recipeLayerFolders() {
+		for _, file := range searchFolders(lf.folder) {
+			if !recipe.IsFolderFile(file) {
+				continue
+			}
+			folder, err := recipe.LoadFolderYaml(file)
+			if err != nil {
+				log.Printf("%s: Broken folder file: %s failed to parse yaml:%s", color.YellowString("WARN"), file, err.Error())
+				continue
+			}
+			folders[path.Dir(path.Join(recipeParts(file)...))] = folder
+		}
+	}
+	return folders
+}
+
+// loadRecipes loads every recipe of all layers keyed by its dotted name.
+func loadRecipes() (map[string]*recipe.BladeRecipeYaml, error) {
+	names, layers := findRecipeLayers()
+	recipes := make(map[string]*recipe.BladeRecipeYaml)
+	for _, name := range names {
+		rec, err := loadRecipe(name, layers[name])
+		if err != nil {
+			return nil, err
+		}
+		recipes[name] = rec
+	}
+	return recipes, nil
+}
+
+// loadRecipe merges the layers of a recipe, whose file is the one of the highest layer, which
+// is never a folder file.
+func loadRecipe(name string, layers []*recipe.Layer) (*recipe.BladeRecipeYaml, error) {
+	rec, err := recipe.LoadLayeredRecipe(layers)
+	if err != nil {
+		return nil, err
+	}
+	rec.Name = name
+	rec.Filename = layers[len(layers)-1].File
+	return rec, nil
+}
@@ -43,6 +43,6 @@ var pipeCmd = &cobra.Command{
 		if err != nil {
 			log.Printf("Failed to execute command: %s\n", c)
 		}
-		fmt.Printf(string(out))
+		fmt.Print(string(out))
 	},
 }
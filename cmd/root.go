@@ -22,9 +22,18 @@ SOFTWARE.
 package cmd
 
 import (
+	"log"
+	"os"
+
+	"github.com/deckarep/blade/lib/recipe"
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	// Errors may carry the values of secret args, those never make it into a log line.
+	log.SetOutput(recipe.RedactingWriter(os.Stderr))
+}
+
 // Blade is opinioned on how the recipe/folder works
 // But not opinioned on how it's structured to match your infrastructure/use-case.
 var RootCmd = &cobra.Command{
@@ -22,12 +22,14 @@ SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/deckarep/blade/lib/recipe"
 	bladessh "github.com/deckarep/blade/lib/ssh"
@@ -41,16 +43,46 @@ const (
 	bladeRecipesFolder = "recipes"
 )
 
+// Exit codes of `blade run` so that wrappers such as CI jobs and cron can trust the outcome.
+const (
+	exitCodeSuccess        = 0
+	exitCodeTotalFailure   = 1
+	exitCodeUsageError     = 2
+	exitCodePartialFailure = 3
+	exitCodeInterrupted    = 130
+)
+
 // Flag variables
 var (
-	helpFlag    bool
-	retries     int
-	concurrency int
-	hosts       string
-	port        int
-	user        string
-	quiet       bool
-	verbose     bool
+	helpFlag       bool
+	retries        int
+	dialRetries    int
+	commandRetries int
+	concurrency    int
+
+	batchSize            string
+	batchPause           time.Duration
+	batchConfirm         bool
+	maxFailures          int
+	maxFailurePercentage int
+
+	canaryCount   int
+	canaryHosts   string
+	canaryConfirm bool
+
+	dialTimeout    time.Duration
+	commandTimeout time.Duration
+	hostTimeout    time.Duration
+	runTimeout     time.Duration
+	gracePeriod    time.Duration
+	failFast       bool
+	dryRun         bool
+	assumeYes      bool
+	hosts          string
+	port           int
+	user           string
+	quiet          bool
+	verbose        bool
 )
 
 // blade ssh deploy-cloud-server-a // matches a recipe and therefore will follow the recipe guidelines against servers defined in recipe
@@ -87,11 +119,47 @@ func init() {
 	runCmd.PersistentFlags().IntVarP(&concurrency,
 		"concurrency", "c", 0, "Max concurrency when running ssh commands")
 	runCmd.PersistentFlags().IntVarP(&retries,
-		"retries", "r", 3, "Number of times to retry until a successful command returns")
+		"retries", "r", 0, "Number of times to retry a failed dial or command, overrides the recipe resilience")
+	runCmd.PersistentFlags().IntVarP(&dialRetries,
+		"dial-retries", "", 0, "Number of times to retry dialing a host, overrides --retries")
+	runCmd.PersistentFlags().IntVarP(&commandRetries,
+		"command-retries", "", 0, "Number of times to retry a failed command, overrides --retries")
+	runCmd.PersistentFlags().StringVarP(&batchSize,
+		"batch-size", "", "", "Roll out to hosts in batches of a count like 5 or a percentage like 10%")
+	runCmd.PersistentFlags().DurationVarP(&batchPause,
+		"batch-pause", "", 0, "Time to wait between two batches like 30s")
+	runCmd.PersistentFlags().BoolVarP(&batchConfirm,
+		"batch-confirm", "", false, "Prompt for confirmation before every batch after the first")
+	runCmd.PersistentFlags().IntVarP(&maxFailures,
+		"max-failures", "", 0, "Abort the remaining batches once more than this many hosts have failed")
+	runCmd.PersistentFlags().IntVarP(&maxFailurePercentage,
+		"max-failure-percentage", "", 0, "Abort the remaining batches once more than this percentage of hosts have failed")
+	runCmd.PersistentFlags().IntVarP(&canaryCount,
+		"canary", "", 0, "Run on the first N hosts and only release the rest once they all succeeded")
+	runCmd.PersistentFlags().StringVarP(&canaryHosts,
+		"canary-hosts", "", "", "One or more comma-delimited hosts to run as canaries, overrides --canary")
+	runCmd.PersistentFlags().BoolVarP(&canaryConfirm,
+		"canary-confirm", "", false, "Prompt for confirmation before releasing the hosts after the canaries")
+	runCmd.PersistentFlags().DurationVarP(&dialTimeout,
+		"dial-timeout", "", 0, "Time allowed to establish an ssh connection, defaults to 30s")
+	runCmd.PersistentFlags().DurationVarP(&commandTimeout,
+		"command-timeout", "", 0, "Time allowed for every single command attempt")
+	runCmd.PersistentFlags().DurationVarP(&hostTimeout,
+		"host-timeout", "", 0, "Time allowed for all commands on a single host")
+	runCmd.PersistentFlags().DurationVarP(&runTimeout,
+		"timeout", "", 0, "Deadline for the whole run, hosts not yet started are skipped")
+	runCmd.PersistentFlags().DurationVarP(&gracePeriod,
+		"grace-period", "", 10*time.Second, "Time commands in flight get to finish after an interrupt before connections are closed")
+	runCmd.PersistentFlags().BoolVarP(&failFast,
+		"fail-fast", "", false, "Cancel every host as soon as any host fails")
 	runCmd.PersistentFlags().IntVarP(&port,
 		"port", "p", 22, "The ssh port to use")
 	runCmd.PersistentFlags().StringVarP(&user,
-		"user", "u", "root", "user for ssh host login.")
+		"user", "u", "", "user for ssh host login, overrides the recipe and ~/.ssh/config.")
+	runCmd.PersistentFlags().BoolVarP(&assumeYes,
+		"yes", "y", false, "Answer yes to every confirmation, needed to run recipes that prompt without a terminal")
+	runCmd.PersistentFlags().BoolVarP(&dryRun,
+		"dry-run", "", false, "Print the hosts, users, commands and policies that would be used without dialing any host")
 	runCmd.PersistentFlags().BoolVarP(&quiet,
 		"quiet", "q", false, "quiet mode will keep Blade as silent as possible.")
 	runCmd.PersistentFlags().BoolVarP(&verbose,
@@ -107,16 +175,54 @@ var runCmd = &cobra.Command{
 
 func validateFlags() {
 	if concurrency < 0 {
-		log.Fatal("The specified --concurrency flag must not be a negative number.")
+		usageFatal("The specified --concurrency flag must not be a negative number.")
 	}
 	if port < 22 {
-		log.Fatal("The specified --port flag must be 22 or greater.")
+		usageFatal("The specified --port flag must be 22 or greater.")
 	}
 	if quiet && verbose {
-		log.Fatal("You must specify either --quiet or --verbose but not both.")
+		usageFatal("You must specify either --quiet or --verbose but not both.")
+	}
+	if retries < 0 || dialRetries < 0 || commandRetries < 0 {
+		usageFatal("The specified --retries, --dial-retries and --command-retries flags must not be negative numbers.")
+	}
+	if maxFailures < 0 {
+		usageFatal("The specified --max-failures flag must not be a negative number.")
+	}
+	if maxFailurePercentage < 0 || maxFailurePercentage > 100 {
+		usageFatal("The specified --max-failure-percentage flag must be between 0 and 100.")
 	}
-	if retries < 0 {
-		log.Fatal("The specified --retries flag must not be a negative number.")
+	if canaryCount < 0 {
+		usageFatal("The specified --canary flag must not be a negative number.")
+	}
+	if dialTimeout < 0 || commandTimeout < 0 || hostTimeout < 0 || runTimeout < 0 {
+		usageFatal("The specified timeout flags must not be negative.")
+	}
+	if gracePeriod < 0 {
+		usageFatal("The specified --grace-period flag must not be negative.")
+	}
+	if batchPause < 0 {
+		usageFatal("The specified --batch-pause flag must not be negative.")
+	}
+}
+
+// usageFatal logs the message and exits with the usage error exit code.
+func usageFatal(v ...interface{}) {
+	log.Print(v...)
+	os.Exit(exitCodeUsageError)
+}
+
+// exitCodeForResult maps a session result onto the exit code of `blade run`.
+func exitCodeForResult(result *bladessh.SessionResult) int {
+	switch {
+	case result.Interrupted:
+		return exitCodeInterrupted
+	case result.Failed() == 0 && len(result.Skipped) == 0 && len(result.SkippedStages) == 0 && !result.LocalFailed():
+		return exitCodeSuccess
+	case result.Succeeded() == 0:
+		return exitCodeTotalFailure
+	default:
+		return exitCodePartialFailure
 	}
 }
 
@@ -128,16 +234,54 @@ func applyRecipeFlagOverrides(currentRecipe *recipe.BladeRecipeYaml, cobraComman
 	}
 }
 
-func applyFlagOverrides(recipe *recipe.BladeRecipeYaml, modifier *bladessh.SessionModifier) {
+func applyFlagOverrides(cobraCommand *cobra.Command, recipe *recipe.BladeRecipeYaml, modifier *bladessh.SessionModifier) {
 	if hosts != "" {
 		modifier.FlagOverrides.Hosts = strings.Split(strings.TrimSpace(hosts), ",")
 	}
 	if concurrency > 0 {
 		modifier.FlagOverrides.Concurrency = concurrency
 	}
-	if port > 0 {
+	// The port and user have their own defaults further down so only explicit flags count.
+	if cobraCommand.Flags().Changed("port") {
 		modifier.FlagOverrides.Port = port
 	}
+	if user != "" {
+		modifier.FlagOverrides.User = user
+	}
+	// Zero is a meaningful number of retries, so only flags that were actually set override the recipe.
+	if cobraCommand.Flags().Changed("retries") {
+		modifier.FlagOverrides.Retries = &retries
+	}
+	if cobraCommand.Flags().Changed("dial-retries") {
+		modifier.FlagOverrides.DialRetries = &dialRetries
+	}
+	if cobraCommand.Flags().Changed("command-retries") {
+		modifier.FlagOverrides.CommandRetries = &commandRetries
+	}
+	modifier.FlagOverrides.BatchSize = batchSize
+	modifier.FlagOverrides.BatchPause = batchPause
+	modifier.FlagOverrides.BatchConfirm = batchConfirm
+	if cobraCommand.Flags().Changed("max-failures") {
+		modifier.FlagOverrides.MaxFailures = &maxFailures
+	}
+	if cobraCommand.Flags().Changed("max-failure-percentage") {
+		modifier.FlagOverrides.MaxFailurePercentage = &maxFailurePercentage
+	}
+	modifier.FlagOverrides.CanaryCount = canaryCount
+	if canaryHosts != "" {
+		modifier.FlagOverrides.CanaryHosts = strings.Split(strings.TrimSpace(canaryHosts), ",")
+	}
+	modifier.FlagOverrides.CanaryConfirm = canaryConfirm
+	modifier.FlagOverrides.DialTimeout = dialTimeout
+	modifier.FlagOverrides.CommandTimeout = commandTimeout
+	modifier.FlagOverrides.HostTimeout = hostTimeout
+	modifier.FlagOverrides.RunTimeout = runTimeout
+	modifier.FlagOverrides.FailFast = failFast
+	modifier.Confirm = confirmPrompt
+	if assumeYes {
+		modifier.Confirm = confirmAll
+	}
+	modifier.Approve = approvePlan
 }
 
 func searchFolders(folders ...string) []string {
@@ -190,49 +334,70 @@ func indexOfRecipeFolder(parts []string) int {
 	return recipeIndex
 }
 
+// linkStagesErr is why the stages of recipes couldn't be linked, if they couldn't.
+var linkStagesErr error
+
 func generateCommandLine() {
-	fileList := searchFolders(userHomeDir(), bladeRecipesFolder)
+	names, layers := findRecipeLayers()
+	folders := loadFolders()
 	commands := make(map[string]*cobra.Command)
 
-	for _, file := range fileList {
-		currentRecipe, err := recipe.LoadRecipeYaml(file)
+	recipes := make(map[string]*recipe.BladeRecipeYaml)
+
+	// A recipe found in several layers is a single command made up of all of them.
+	for _, name := range names {
+		currentRecipe, err := loadRecipe(name, layers[name])
 		if err != nil {
+			file := layers[name][len(layers[name])-1].File
 			log.Fatalf("%s: Broken recipe: %s failed to parse yaml:%s\n", color.RedString("ERROR"), file, err.Error())
 		}
+		recipes[name] = currentRecipe
 
-		// Find and drop all /recipes folders including all parent dirs.
-		parts := strings.Split(file, "/")
-		remainingParts := parts[indexOfRecipeFolder(parts)+1:]
-
-		currentRecipe.Name = strings.TrimSuffix(strings.Join(remainingParts, "."), bladeRecipeSuffix)
-		currentRecipe.Filename = file
+		remainingParts := recipeParts(currentRecipe.Filename)
 
 		var lastCommand *cobra.Command
-		for _, part := range remainingParts {
-			handleRecipeComponent(commands, part, &lastCommand, currentRecipe)
+		for i, part := range remainingParts {
+			key := path.Join(remainingParts[:i+1]...)
+			handleRecipeComponent(commands, key, part, &lastCommand, currentRecipe, folders[key])
 		}
 	}
+
+	// Stages can only be linked once every recipe they might reference is loaded. A broken
+	// link only stops recipes with stages from running, blade validate reports it too.
+	linkStagesErr = recipe.LinkStages(recipes)
+}
+
+// recipeParts drops every /recipes folder including all parent dirs off a recipe file.
+func recipeParts(file string) []string {
+	parts := strings.Split(file, "/")
+	return parts[indexOfRecipeFolder(parts)+1:]
+}
+
+// recipeName is the dotted name of a recipe file like arsenic.mail-server.linux.
+func recipeName(file string) string {
+	return strings.TrimSuffix(strings.Join(recipeParts(file), "."), bladeRecipeSuffix)
 }
 
 // handleRecipeComponent needs to send a pointer to a pointer for lastCommand so that the state can be observed in method above.
-func handleRecipeComponent(commands map[string]*cobra.Command, part string, lastCommand **cobra.Command, currentRecipe *recipe.BladeRecipeYaml) {
+// Commands are keyed by their full path like prod/db/restart.blade.yaml so that folders of the
+// same name in different hierarchies stay apart. A folder command is documented by its folder
+// file, if it has one.
+func handleRecipeComponent(commands map[string]*cobra.Command, key, part string, lastCommand **cobra.Command, currentRecipe *recipe.BladeRecipeYaml, folder *recipe.BladeFolderYaml) {
 	var currentCommand *cobra.Command
 
-	// Known bug, we need to dedup these, but add them to a map based on their full path.
-	// Reason is: if you have the same folder name in different hiearchies you'll collide.
-	// This way we can add docs to describe command hiearchies when user uses the --help system.
 	recipeAlreadyFound := false
-	if _, ok := commands[part]; !ok {
+	if _, ok := commands[key]; !ok {
 		// If not found create it.
 		currentCommand = &cobra.Command{
-			Use:   part,
-			Short: currentRecipe.Help.Short,
-			Long:  currentRecipe.Help.Long,
+			Use: part,
 		}
-		commands[part] = currentCommand
+		if folder != nil {
+			currentCommand.Short, currentCommand.Long = folder.Help.Short, folder.Help.Long
+		}
+		commands[key] = currentCommand
 	} else {
 		// If found use it.
-		currentCommand = commands[part]
+		currentCommand = commands[key]
 		recipeAlreadyFound = true
 	}
 
@@ -240,15 +405,53 @@ func handleRecipeComponent(commands map[string]*cobra.Command, part string, last
 	if strings.HasSuffix(part, bladeRecipeSuffix) {
 		// Set the Use to just {recipe-name} of {recipe-name}.{bladeRecipeSuffix}.
 		currentCommand.Use = strings.TrimSuffix(part, bladeRecipeSuffix)
+		currentCommand.Short = currentRecipe.Help.Short
+		currentCommand.Long = currentRecipe.Help.Long
+		currentCommand.Aliases = currentRecipe.Aliases
+		currentCommand.Hidden = currentRecipe.Hidden
+		if currentRecipe.Deprecated != "" {
+			// Cobra prints the message whenever the command is used and leaves it out of the
+			// commands listed by its parent.
+			currentCommand.Deprecated = currentRecipe.Deprecated
+			description := currentCommand.Long
+			if description == "" {
+				description = currentCommand.Short
+			}
+			currentCommand.Long = strings.TrimSpace(description + "\n\nDeprecated: " + currentRecipe.Deprecated)
+		}
 		applyRecipeFlagOverrides(currentRecipe, currentCommand)
 		currentCommand.Run = func(cmd *cobra.Command, args []string) {
+			if len(currentRecipe.Stages) > 0 && linkStagesErr != nil {
+				usageFatal(color.RedString("ERROR")+": Broken recipe: ", linkStagesErr.Error())
+			}
 			// Apply validation of flags if used.
 			validateFlags()
 			modifier := bladessh.NewSessionModifier()
 			// Apply flag overrides to the recipe here.
-			applyFlagOverrides(currentRecipe, modifier)
+			applyFlagOverrides(cmd, currentRecipe, modifier)
 			// Finally kick off session of requests.
-			bladessh.StartSession(currentRecipe, modifier)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			session := bladessh.NewSession(currentRecipe, modifier)
+			if dryRun {
+				plan, err := session.Plan()
+				if err != nil {
+					usageFatal(color.RedString("ERROR")+": ", err.Error())
+				}
+				plan.Describe(recipe.RedactingWriter(os.Stdout))
+				os.Exit(exitCodeSuccess)
+			}
+			stopInterrupts := handleInterrupts(session, gracePeriod, cancel)
+			result, err := session.Start(ctx)
+			stopInterrupts()
+			if err == bladessh.ErrNotApproved {
+				log.Print(color.YellowString("Cancelled") + ": nothing was run")
+				os.Exit(exitCodeTotalFailure)
+			}
+			if err != nil {
+				usageFatal(color.RedString("ERROR")+": ", err.Error())
+			}
+			os.Exit(exitCodeForResult(result))
 		}
 	}
 
@@ -0,0 +1,102 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var showLayers bool
+
+func init() {
+	showCmd.Flags().BoolVarP(&showLayers, "layers", "", false, "Show the layer that contributed every field")
+	RootCmd.AddCommand(showCmd)
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show <recipe>",
+	Short: "show prints a recipe as it is made up of all of its layers",
+	Long: `show prints every field of a recipe, given by its dotted name like arsenic.mail-server.linux,
+after merging the layers it is found in: ` + systemRecipesFolder + `, the team's ./recipes and
+the user's ~/.blade/recipes, where a higher layer only needs the fields it changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			usageFatal(color.RedString("ERROR")+": ", "blade show needs the name of a recipe")
+		}
+		name := strings.Join(args, ".")
+
+		_, layers := findRecipeLayers()
+		recipeLayers, ok := layers[name]
+		if !ok {
+			usageFatal(color.RedString("ERROR")+": ", fmt.Sprintf("No recipe named %s was found", name))
+		}
+
+		fields, err := recipe.LayerSources(recipeLayers)
+		if err != nil {
+			usageFatal(color.RedString("ERROR")+": ", err.Error())
+		}
+
+		rec, err := loadRecipe(name, recipeLayers)
+		if err != nil {
+			usageFatal(color.RedString("ERROR")+": ", err.Error())
+		}
+
+		w := tabwriter.NewWriter(recipe.RedactingWriter(os.Stdout), 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Recipe: %s\n", name)
+		if showLayers {
+			fmt.Fprintln(w, "Layers:")
+			for _, layer := range recipeLayers {
+				fmt.Fprintf(w, "  %s\t%s\n", layer.Name, layer.File)
+			}
+			fmt.Fprintln(w, "Fields:")
+		}
+		for _, field := range fields {
+			value := recipe.FormatYamlValue(field.Value)
+			if isSensitiveValue(rec, field.Path) {
+				value = "****"
+			}
+			if showLayers {
+				fmt.Fprintf(w, "  %s: %s\t(%s)\n", field.Path, value, field.Layer.Name)
+			} else {
+				fmt.Fprintf(w, "  %s: %s\n", field.Path, value)
+			}
+		}
+		w.Flush()
+	},
+}
+
+// isSensitiveValue reports whether path is the value of a sensitive argument.
+func isSensitiveValue(rec *recipe.BladeRecipeYaml, path string) bool {
+	parts := strings.Split(path, ".")
+	if len(parts) != 3 || parts[0] != "args" || parts[2] != "value" {
+		return false
+	}
+	arg, ok := rec.Args[parts[1]]
+	return ok && (arg.Sensitive || arg.Secret != nil)
+}
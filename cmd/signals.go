@@ -0,0 +1,76 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	bladessh "github.com/deckarep/blade/lib/ssh"
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh"
+)
+
+// handleInterrupts traps SIGINT and SIGTERM for the duration of a session. The first signal
+// stops new hosts from starting and is forwarded to the remote commands in flight, which get
+// the grace period to finish. A second signal, or the end of the grace period, force-closes
+// every connection through forceStop. The returned func stops trapping signals.
+func handleInterrupts(session *bladessh.Session, gracePeriod time.Duration, forceStop context.CancelFunc) (stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		var sig os.Signal
+		select {
+		case sig = <-signals:
+		case <-done:
+			return
+		}
+
+		log.Print(color.YellowString("Interrupted: no further hosts will start, waiting up to %s for commands in flight. Interrupt again to force close.", gracePeriod))
+		remoteSignal := ssh.SIGINT
+		if sig == syscall.SIGTERM {
+			remoteSignal = ssh.SIGTERM
+		}
+		session.Interrupt(remoteSignal)
+
+		select {
+		case <-signals:
+			log.Print(color.RedString("Interrupted again: force closing all connections."))
+		case <-time.After(gracePeriod):
+			log.Print(color.RedString("Grace period expired: force closing all connections."))
+		case <-done:
+			return
+		}
+		forceStop()
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [path...]",
+	Short: "validate strictly checks recipes for mistakes",
+	Long: `validate strictly checks recipe files, or every recipe within folders, for unknown keys,
+values of the wrong type, arguments that don't match the commands, empty exec lists,
+recipes without hosts and stages that reference unknown recipes or form a cycle.
+Without a path the recipes of every layer folder that exists are checked, like ./recipes and ~/.blade/recipes. It exits non-zero when a problem is found so it can run as a pre-commit hook.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var files []string
+		if len(args) == 0 {
+			// Missing layer folders are skipped, as they are when running recipes.
+			for i, lf := range recipeLayerFolders() {
+				if overlapsEarlierLayer(i) {
+					continue
+				}
+				files = append(files, searchFolders(lf.folder)...)
+			}
+		}
+		for _, p := range args {
+			info, err := os.Stat(p)
+			if err != nil {
+				usageFatal(color.RedString("ERROR")+": ", err.Error())
+			}
+			if info.IsDir() {
+				files = append(files, walkFolder(p)...)
+			} else {
+				files = append(files, p)
+			}
+		}
+
+		// An overlay is checked along with the layers below it.
+		_, layers := findRecipeLayers()
+		lowerLayers := func(file string) []*recipe.Layer {
+			fileLayers := layers[recipeName(file)]
+			for i, layer := range fileLayers {
+				if sameFile(layer.File, file) {
+					return fileLayers[:i]
+				}
+			}
+			return nil
+		}
+
+		problems := 0
+		for _, file := range files {
+			validate := func(file string) ([]*recipe.Problem, error) {
+				return recipe.ValidateRecipeFile(file, lowerLayers(file)...)
+			}
+			if recipe.IsFolderFile(file) {
+				validate = recipe.ValidateFolderFile
+			}
+			found, err := validate(file)
+			if err != nil {
+				usageFatal(color.RedString("ERROR")+": ", err.Error())
+			}
+			for _, problem := range found {
+				if problem.Warning {
+					fmt.Println(color.YellowString(problem.String()))
+					continue
+				}
+				fmt.Println(color.RedString(problem.String()))
+				problems++
+			}
+		}
+
+		// Stages may reference any installed recipe, the ones being validated win on a name clash.
+		recipes := make(map[string]*recipe.BladeRecipeYaml)
+		for name, nameLayers := range layers {
+			if rec, err := loadRecipe(name, nameLayers); err == nil {
+				recipes[name] = rec
+			}
+		}
+		for _, file := range files {
+			if recipe.IsFolderFile(file) {
+				continue
+			}
+			rec, err := loadRecipe(recipeName(file), append(lowerLayers(file), &recipe.Layer{File: file}))
+			if err != nil {
+				// Already reported above.
+				continue
+			}
+			recipes[rec.Name] = rec
+		}
+		if err := recipe.LinkStages(recipes); err != nil {
+			fmt.Println(color.RedString(recipe.StageProblem(err).String()))
+			problems++
+		}
+
+		// Folder files aren't recipes of their own.
+		count := 0
+		for _, file := range files {
+			if !recipe.IsFolderFile(file) {
+				count++
+			}
+		}
+		if problems > 0 {
+			log.Printf("%d problems found in %d recipes", problems, count)
+			os.Exit(1)
+		}
+		log.Print(color.GreenString(fmt.Sprintf("%d recipes are valid", count)))
+	},
+}
+
+// overlapsEarlierLayer reports whether the layer folder at index i is also an earlier one,
+// like ./recipes when run from ~/.blade.
+func overlapsEarlierLayer(i int) bool {
+	folders := recipeLayerFolders()
+	for _, earlier := range folders[:i] {
+		if sameFile(earlier.folder, folders[i].folder) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameFile reports whether two paths name the same file.
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
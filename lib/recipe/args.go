@@ -0,0 +1,246 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hil/ast"
+)
+
+// The types an argument can have, an argument without a type is a string.
+const (
+	ArgString   = "string"
+	ArgInt      = "int"
+	ArgBool     = "bool"
+	ArgDuration = "duration"
+	ArgList     = "list"
+	ArgEnum     = "enum"
+)
+
+func (a *BladeArgumentDetails) argType() string {
+	if a.Type == "" {
+		return ArgString
+	}
+	return strings.ToLower(a.Type)
+}
+
+// Variables resolves and validates every argument, returning them as HIL variables of
+// their own type so that expressions like ${count + 1} or ${force} work as expected.
+func (args BladeRecipeArguments) Variables() (map[string]ast.Variable, error) {
+	var names []string
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := make(map[string]ast.Variable, len(args))
+	for _, name := range names {
+		v, err := args[name].Variable()
+		if err != nil {
+			return nil, err
+		}
+		vars[name] = v
+	}
+	return vars, nil
+}
+
+// Variable resolves the argument from its flag or else its recipe value and validates it.
+func (a *BladeArgumentDetails) Variable() (ast.Variable, error) {
+	_, fromSecret, err := a.secret()
+	if err != nil {
+		return ast.Variable{}, fmt.Errorf("Flag \"--%s\": %s", a.argName, err.Error())
+	}
+	if a.Required && !a.flagGiven() && !fromSecret {
+		return ast.Variable{}, fmt.Errorf("Flag \"--%s\" is required", a.argName)
+	}
+
+	raw, ok, err := a.value()
+	if err != nil {
+		return ast.Variable{}, fmt.Errorf("Flag \"--%s\": %s", a.argName, err.Error())
+	}
+	if !ok {
+		if a.Secret != nil {
+			return ast.Variable{}, fmt.Errorf("Flag \"--%s\" is a secret that none of its sources provided", a.argName)
+		}
+		if a.argType() != ArgBool {
+			return ast.Variable{}, fmt.Errorf("Flag \"--%s\" should be supplied as an argument since no default value is provided in the recipe", a.argName)
+		}
+		raw = "false"
+	}
+
+	if a.sensitive() {
+		a.addSecret(raw)
+	}
+	v, err := a.parse(raw)
+	if err != nil {
+		return ast.Variable{}, fmt.Errorf("Flag \"--%s\": %s", a.argName, err.Error())
+	}
+	return v, nil
+}
+
+// check reports mistakes in the definition of the argument itself, including a recipe
+// value that would never pass validation.
+func (a *BladeArgumentDetails) check() error {
+	switch a.argType() {
+	case ArgString, ArgInt, ArgBool, ArgDuration, ArgList:
+		if len(a.Choices) > 0 && a.argType() != ArgList {
+			return fmt.Errorf("choices only apply to the enum and list types, not %s", a.argType())
+		}
+	case ArgEnum:
+		if len(a.Choices) == 0 {
+			return fmt.Errorf("an enum needs choices")
+		}
+	default:
+		return fmt.Errorf("unknown type %q, expected one of string, int, bool, duration, list or enum", a.Type)
+	}
+
+	if a.Pattern != "" {
+		if _, err := regexp.Compile(a.Pattern); err != nil {
+			return fmt.Errorf("pattern doesn't compile: %s", err.Error())
+		}
+	}
+	if (a.Min != nil || a.Max != nil) && a.argType() != ArgInt && a.argType() != ArgList {
+		return fmt.Errorf("min and max only apply to the int and list types, not %s", a.argType())
+	}
+	if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+		return fmt.Errorf("min %d is greater than max %d", *a.Min, *a.Max)
+	}
+
+	if a.Secret != nil && a.Secret.Env == "" && a.Secret.File == "" && a.Secret.Command == "" && !a.Secret.Prompt {
+		return fmt.Errorf("secret has no source, expected env, file, command or prompt")
+	}
+
+	if a.Value != "" {
+		if _, err := a.parse(a.Value); err != nil {
+			return fmt.Errorf("value: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// parse converts raw to the type of the argument and applies its constraints.
+func (a *BladeArgumentDetails) parse(raw string) (ast.Variable, error) {
+	switch a.argType() {
+	case ArgInt:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return ast.Variable{}, fmt.Errorf("%s is not an int", a.describe(raw))
+		}
+		if err := a.checkRange(n, "is"); err != nil {
+			return ast.Variable{}, err
+		}
+		return ast.Variable{Type: ast.TypeInt, Value: n}, nil
+
+	case ArgBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return ast.Variable{}, fmt.Errorf("%s is not a bool", a.describe(raw))
+		}
+		return ast.Variable{Type: ast.TypeBool, Value: b}, nil
+
+	case ArgDuration:
+		if _, err := time.ParseDuration(strings.TrimSpace(raw)); err != nil {
+			return ast.Variable{}, fmt.Errorf("%s is not a duration like 30s or 5m", a.describe(raw))
+		}
+		return ast.Variable{Type: ast.TypeString, Value: strings.TrimSpace(raw)}, nil
+
+	case ArgList:
+		items := splitList(raw)
+		if err := a.checkRange(len(items), "items are"); err != nil {
+			return ast.Variable{}, err
+		}
+		list := make([]ast.Variable, 0, len(items))
+		for _, item := range items {
+			if err := a.checkString(item); err != nil {
+				return ast.Variable{}, err
+			}
+			list = append(list, ast.Variable{Type: ast.TypeString, Value: item})
+		}
+		return ast.Variable{Type: ast.TypeList, Value: list}, nil
+
+	default:
+		if err := a.checkString(raw); err != nil {
+			return ast.Variable{}, err
+		}
+		return ast.Variable{Type: ast.TypeString, Value: raw}, nil
+	}
+}
+
+func (a *BladeArgumentDetails) checkString(s string) error {
+	if len(a.Choices) > 0 {
+		found := false
+		for _, choice := range a.Choices {
+			if s == choice {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not one of: %s", a.describe(s), strings.Join(a.Choices, ", "))
+		}
+	}
+	if a.Pattern != "" {
+		re, err := regexp.Compile(a.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern doesn't compile: %s", err.Error())
+		}
+		if !re.MatchString(s) {
+			return fmt.Errorf("%s doesn't match pattern %s", a.describe(s), a.Pattern)
+		}
+	}
+	return nil
+}
+
+func (a *BladeArgumentDetails) checkRange(n int, what string) error {
+	if a.Min != nil && n < *a.Min {
+		return fmt.Errorf("%d %s below the min of %d", n, what, *a.Min)
+	}
+	if a.Max != nil && n > *a.Max {
+		return fmt.Errorf("%d %s above the max of %d", n, what, *a.Max)
+	}
+	return nil
+}
+
+// describe quotes a value for an error message unless the argument is sensitive.
+func (a *BladeArgumentDetails) describe(s string) string {
+	if a.sensitive() {
+		return "the value"
+	}
+	return strconv.Quote(s)
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
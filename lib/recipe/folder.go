@@ -0,0 +1,105 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+
+	yaml "gopkg.in/yaml.v1"
+)
+
+// FolderFile documents the folder of recipes it is in and provides defaults for every
+// recipe below that folder.
+const FolderFile = "_folder.blade.yaml"
+
+// BladeFolderYaml is the FolderFile of a folder. Recipes inherit everything but the help of
+// the folders they are in, where nearer folders win and the recipe itself wins over them all.
+type BladeFolderYaml struct {
+	Help *BladeRecipeHelp // <-- the help of the folder's command
+
+	Hosts      []string
+	HostLookup string
+	Args       BladeRecipeArguments
+	Overrides  *BladeRecipeOverrides
+	Resilience *BladeRecipeResilience
+}
+
+// folderDefaults are the keys of a FolderFile that recipes inherit.
+var folderDefaults = map[string]bool{
+	"hosts":      true,
+	"hostlookup": true,
+	"args":       true,
+	"overrides":  true,
+	"resilience": true,
+}
+
+// IsFolderFile reports whether path is a FolderFile rather than a recipe.
+func IsFolderFile(path string) bool {
+	return filepath.Base(path) == FolderFile
+}
+
+// LoadFolderYaml loads the FolderFile at path.
+func LoadFolderYaml(path string) (*BladeFolderYaml, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var folder BladeFolderYaml
+	if err := yaml.Unmarshal(b, &folder); err != nil {
+		return nil, err
+	}
+	if folder.Help == nil {
+		folder.Help = &BladeRecipeHelp{}
+	}
+	return &folder, nil
+}
+
+// ValidateFolderFile strictly checks the FolderFile at path like ValidateRecipeFile does
+// a recipe. Its args aren't reported as unused since the recipes below use them.
+func ValidateFolderFile(path string) ([]*Problem, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &validator{file: path, lines: indexYamlLines(b)}
+
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		v.report(yamlErrorLine(err), "%s", err.Error())
+		return v.problems, nil
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	v.checkValue("", raw, reflect.TypeOf(BladeFolderYaml{}))
+
+	var folder BladeFolderYaml
+	if err := yaml.Unmarshal(b, &folder); err != nil {
+		v.report(yamlErrorLine(err), "%s", err.Error())
+		return v.problems, nil
+	}
+	v.checkArgs(folder.Args)
+	return v.problems, nil
+}
@@ -0,0 +1,183 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hil"
+	"github.com/hashicorp/hil/ast"
+)
+
+// NewEvalConfig returns the HIL config used to interpolate recipes, binding the given
+// variables along with the functions of Funcs.
+func NewEvalConfig(vars map[string]ast.Variable) *hil.EvalConfig {
+	if vars == nil {
+		vars = make(map[string]ast.Variable)
+	}
+	funcs := Funcs()
+	// Only QuoteInterpolations calls these, recipes use shellquote.
+	funcs[funcQuoteSingle] = stringFunc(quoteSingle)
+	funcs[funcQuoteDouble] = stringFunc(quoteDouble)
+	return &hil.EvalConfig{
+		GlobalScope: &ast.BasicScope{
+			VarMap:  vars,
+			FuncMap: funcs,
+		},
+	}
+}
+
+// Funcs returns the functions available to ${...} expressions in recipes.
+func Funcs() map[string]ast.Function {
+	return map[string]ast.Function{
+		"upper":       stringFunc(strings.ToUpper),
+		"lower":       stringFunc(strings.ToLower),
+		"trim":        stringFunc(strings.TrimSpace),
+		"shellquote":  stringFunc(ShellQuote),
+		"raw":         stringFunc(func(s string) string { return s }),
+		"base64":      stringFunc(func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }),
+		"sha256":      stringFunc(func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) }),
+		"env":         stringFunc(os.Getenv),
+		"replace":     funcReplace(),
+		"join":        funcJoin(),
+		"split":       funcSplit(),
+		"default":     funcDefault(),
+		"file":        funcFile(),
+		"timestamp":   funcTimestamp(),
+		"format-time": funcFormatTime(),
+	}
+}
+
+// stringFunc wraps a plain string function.
+func stringFunc(f func(string) string) ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{ast.TypeString},
+		ReturnType: ast.TypeString,
+		Callback: func(args []interface{}) (interface{}, error) {
+			return f(args[0].(string)), nil
+		},
+	}
+}
+
+// funcReplace replaces every occurrence of old in s: replace(s, old, new).
+func funcReplace() ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{ast.TypeString, ast.TypeString, ast.TypeString},
+		ReturnType: ast.TypeString,
+		Callback: func(args []interface{}) (interface{}, error) {
+			return strings.Replace(args[0].(string), args[1].(string), args[2].(string), -1), nil
+		},
+	}
+}
+
+// funcJoin joins the items of a list with a separator: join(sep, list).
+func funcJoin() ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{ast.TypeString, ast.TypeList},
+		ReturnType: ast.TypeString,
+		Callback: func(args []interface{}) (interface{}, error) {
+			list := args[1].([]ast.Variable)
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item.Value)
+			}
+			return strings.Join(items, args[0].(string)), nil
+		},
+	}
+}
+
+// funcSplit splits a string into a list on a separator: split(sep, s).
+func funcSplit() ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{ast.TypeString, ast.TypeString},
+		ReturnType: ast.TypeList,
+		Callback: func(args []interface{}) (interface{}, error) {
+			var list []ast.Variable
+			for _, item := range strings.Split(args[1].(string), args[0].(string)) {
+				list = append(list, ast.Variable{Type: ast.TypeString, Value: item})
+			}
+			return list, nil
+		},
+	}
+}
+
+// funcDefault returns the fallback when the value is empty: default(value, fallback).
+func funcDefault() ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{ast.TypeString, ast.TypeString},
+		ReturnType: ast.TypeString,
+		Callback: func(args []interface{}) (interface{}, error) {
+			if v := args[0].(string); v != "" {
+				return v, nil
+			}
+			return args[1].(string), nil
+		},
+	}
+}
+
+// funcFile reads a local file: file(path).
+func funcFile() ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{ast.TypeString},
+		ReturnType: ast.TypeString,
+		Callback: func(args []interface{}) (interface{}, error) {
+			b, err := ioutil.ReadFile(args[0].(string))
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		},
+	}
+}
+
+// funcTimestamp returns the current time in RFC 3339 format: timestamp().
+func funcTimestamp() ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{},
+		ReturnType: ast.TypeString,
+		Callback: func(args []interface{}) (interface{}, error) {
+			return time.Now().UTC().Format(time.RFC3339), nil
+		},
+	}
+}
+
+// funcFormatTime formats an RFC 3339 time with a Go layout: format-time(layout, time).
+func funcFormatTime() ast.Function {
+	return ast.Function{
+		ArgTypes:   []ast.Type{ast.TypeString, ast.TypeString},
+		ReturnType: ast.TypeString,
+		Callback: func(args []interface{}) (interface{}, error) {
+			t, err := time.Parse(time.RFC3339, args[1].(string))
+			if err != nil {
+				return nil, fmt.Errorf("format-time expects an RFC 3339 time like timestamp() returns: %s", err.Error())
+			}
+			return t.Format(args[0].(string)), nil
+		},
+	}
+}
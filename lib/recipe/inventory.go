@@ -0,0 +1,142 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"net"
+	"strings"
+)
+
+// HostVariables are set for every host on top of the args of a recipe.
+var HostVariables = []string{
+	"host.name",
+	"host.port",
+	"host.user",
+	"host.index",
+	"host.count",
+	"batch.number",
+	"run.id",
+	"run.started_at",
+}
+
+// RunVariables are the variables of a run, which local commands can use as well.
+var RunVariables = []string{
+	"run.id",
+	"run.started_at",
+}
+
+// StepsPrefix and PromotedPrefix prefix what steps registered, like ${steps.version.stdout}
+// on the same host or ${run.steps.version.stdout} when it was promoted to the run.
+const (
+	StepsPrefix    = "steps."
+	PromotedPrefix = "run." + StepsPrefix
+)
+
+// IsCapturedVariable reports whether name refers to what a step registered.
+func IsCapturedVariable(name string) bool {
+	return strings.HasPrefix(name, StepsPrefix) || strings.HasPrefix(name, PromotedPrefix)
+}
+
+// Registers holds the names steps registered so far and which of them were promoted to the
+// run, to tell whether a captured variable refers to anything.
+type Registers struct {
+	steps    map[string]bool
+	promoted map[string]bool
+}
+
+func NewRegisters() *Registers {
+	return &Registers{steps: make(map[string]bool), promoted: make(map[string]bool)}
+}
+
+// Add records that a step registered name, and promoted it to the run when promote is set.
+func (r *Registers) Add(name string, promote bool) {
+	r.steps[name] = true
+	if promote {
+		r.promoted[name] = true
+	}
+}
+
+// Registered reports whether a step registered name.
+func (r *Registers) Registered(name string) bool {
+	return r.steps[name]
+}
+
+// Knows reports whether a captured variable like ${steps.version.stdout} refers to what a step
+// registered, or for ${run.steps.version.stdout} to what a step promoted.
+func (r *Registers) Knows(variable string) bool {
+	registered := r.steps
+	if strings.HasPrefix(variable, PromotedPrefix) {
+		variable, registered = strings.TrimPrefix(variable, "run."), r.promoted
+	}
+	if !strings.HasPrefix(variable, StepsPrefix) {
+		return false
+	}
+	return registered[strings.SplitN(strings.TrimPrefix(variable, StepsPrefix), ".", 2)[0]]
+}
+
+// Run returns what a later recipe of the same run starts out with, which is only what was
+// promoted to the run.
+func (r *Registers) Run() *Registers {
+	run := NewRegisters()
+	for name := range r.promoted {
+		run.promoted[name] = true
+	}
+	return run
+}
+
+// InventoryPrefix prefixes the inventory variables of a host like ${host.vars.role}.
+const InventoryPrefix = "host.vars."
+
+// IsHostVariable reports whether name is one of the variables set for every host.
+func IsHostVariable(name string) bool {
+	if strings.HasPrefix(name, InventoryPrefix) {
+		return true
+	}
+	for _, v := range HostVariables {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRunVariable reports whether name is one of the variables of a run.
+func IsRunVariable(name string) bool {
+	for _, v := range RunVariables {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
+
+// InventoryFor returns the inventory variables of a host given as host:port, which may be
+// listed in the inventory with or without its port.
+func (r *BladeRecipeYaml) InventoryFor(host string) map[string]string {
+	if vars, ok := r.Inventory[host]; ok {
+		return vars
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return r.Inventory[name]
+	}
+	return nil
+}
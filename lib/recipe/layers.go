@@ -0,0 +1,183 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	yaml "gopkg.in/yaml.v1"
+)
+
+// Layer is one file that contributes to a recipe, like the team's shared recipe or the
+// overlay of a single user.
+type Layer struct {
+	Name   string // <-- system, team or user
+	File   string
+	Folder bool // <-- a FolderFile, of which the recipe only inherits the defaults
+}
+
+// FieldSource tells which layer a field of a layered recipe came from.
+type FieldSource struct {
+	Path  string
+	Value interface{}
+	Layer *Layer
+}
+
+// LoadLayeredRecipe loads a recipe made up of layers, given from the lowest to the highest
+// precedence. Mappings are merged key by key so a layer only needs the fields it changes,
+// anything else, lists included, is replaced as a whole by a higher layer.
+func LoadLayeredRecipe(layers []*Layer) (*BladeRecipeYaml, error) {
+	if len(layers) == 1 {
+		return LoadRecipeYaml(layers[0].File)
+	}
+
+	merged, _, err := mergeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecipeYaml(b)
+}
+
+// LayerSources returns every field of a layered recipe along with the layer it came from,
+// sorted by path.
+func LayerSources(layers []*Layer) ([]*FieldSource, error) {
+	merged, sources, err := mergeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []*FieldSource
+	var collect func(path string, value interface{})
+	collect = func(path string, value interface{}) {
+		if m, ok := value.(map[interface{}]interface{}); ok && len(m) > 0 {
+			for _, key := range sortedKeys(m) {
+				collect(joinPath(path, key), m[key])
+			}
+			return
+		}
+		fields = append(fields, &FieldSource{Path: path, Value: value, Layer: sources[path]})
+	}
+	collect("", merged)
+	return fields, nil
+}
+
+func mergeLayers(layers []*Layer) (map[interface{}]interface{}, map[string]*Layer, error) {
+	merged := make(map[interface{}]interface{})
+	sources := make(map[string]*Layer)
+	for _, layer := range layers {
+		b, err := ioutil.ReadFile(layer.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		var m map[interface{}]interface{}
+		if err := yaml.Unmarshal(b, &m); err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", layer.File, err.Error())
+		}
+		if layer.Folder {
+			for key := range m {
+				if k, ok := key.(string); !ok || !folderDefaults[k] {
+					delete(m, key)
+				}
+			}
+		}
+		// Hosts and a hostlookup are two ways of saying the same thing, a layer sets both.
+		if _, ok := m["hosts"]; ok {
+			replaceYaml(merged, "hostlookup", sources)
+		}
+		if _, ok := m["hostlookup"]; ok {
+			replaceYaml(merged, "hosts", sources)
+		}
+		mergeYaml(merged, m, "", layer, sources)
+	}
+	return merged, sources, nil
+}
+
+// mergeYaml merges src into dst, recording the layer of every field that src sets.
+func mergeYaml(dst, src map[interface{}]interface{}, path string, layer *Layer, sources map[string]*Layer) {
+	for _, key := range sortedKeys(src) {
+		value, fieldPath := src[key], joinPath(path, key)
+
+		srcMap, srcIsMap := value.(map[interface{}]interface{})
+		dstMap, dstIsMap := dst[key].(map[interface{}]interface{})
+		if srcIsMap && dstIsMap {
+			mergeYaml(dstMap, srcMap, fieldPath, layer, sources)
+			continue
+		}
+
+		// Whatever a lower layer had under this path is gone now.
+		for p := range sources {
+			if strings.HasPrefix(p, fieldPath+".") {
+				delete(sources, p)
+			}
+		}
+		dst[key] = value
+		markYaml(value, fieldPath, layer, sources)
+	}
+}
+
+// replaceYaml drops a top level key of a lower layer.
+func replaceYaml(merged map[interface{}]interface{}, key string, sources map[string]*Layer) {
+	delete(merged, key)
+	for p := range sources {
+		if p == key || strings.HasPrefix(p, key+".") {
+			delete(sources, p)
+		}
+	}
+}
+
+func markYaml(value interface{}, path string, layer *Layer, sources map[string]*Layer) {
+	if m, ok := value.(map[interface{}]interface{}); ok && len(m) > 0 {
+		for _, key := range sortedKeys(m) {
+			markYaml(m[key], joinPath(path, key), layer, sources)
+		}
+		return
+	}
+	sources[path] = layer
+}
+
+// FormatYamlValue formats a generically decoded yaml value on a single line.
+func FormatYamlValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = FormatYamlValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[interface{}]interface{}:
+		var items []string
+		for _, key := range sortedKeys(v) {
+			items = append(items, key+": "+FormatYamlValue(v[key]))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case nil:
+		return "~"
+	default:
+		return fmt.Sprint(v)
+	}
+}
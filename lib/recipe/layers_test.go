@@ -0,0 +1,179 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v1"
+)
+
+type testLayer struct {
+	name   string
+	folder bool
+	yaml   string
+}
+
+// writeLayers writes every layer to a file of its own in dir, from the lowest to the highest.
+func writeLayers(t *testing.T, dir string, layers []*testLayer) []*Layer {
+	var written []*Layer
+	for i, l := range layers {
+		file := filepath.Join(dir, l.name+".blade.yaml")
+		if l.folder {
+			file = filepath.Join(dir, l.name, FolderFile)
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(file, []byte(l.yaml), 0644); err != nil {
+			t.Fatalf("layer %d: %s", i, err)
+		}
+		written = append(written, &Layer{Name: l.name, File: file, Folder: l.folder})
+	}
+	return written
+}
+
+func TestMergeLayers(t *testing.T) {
+	tests := []struct {
+		name    string
+		layers  []*testLayer
+		want    string
+		sources map[string]string
+	}{
+		{
+			name: "mappings merge key by key",
+			layers: []*testLayer{
+				{name: "system", yaml: "overrides:\n  user: root\n  port: 22\nexec:\n  - echo system\n"},
+				{name: "user", yaml: "overrides:\n  port: 2222\n"},
+			},
+			want:    "overrides:\n  user: root\n  port: 2222\nexec:\n  - echo system\n",
+			sources: map[string]string{"overrides.user": "system", "overrides.port": "user", "exec": "system"},
+		},
+		{
+			name: "lists are replaced",
+			layers: []*testLayer{
+				{name: "system", yaml: "hosts: [a, b]\nexec:\n  - echo one\n  - echo two\n"},
+				{name: "team", yaml: "exec:\n  - echo three\n"},
+			},
+			want:    "hosts: [a, b]\nexec:\n  - echo three\n",
+			sources: map[string]string{"hosts": "system", "exec": "team"},
+		},
+		{
+			name: "a scalar replaces a mapping",
+			layers: []*testLayer{
+				{name: "system", yaml: "args:\n  name:\n    value: x\n    help: the name\n"},
+				{name: "user", yaml: "args:\n  name: ~\n"},
+			},
+			want:    "args:\n  name: ~\n",
+			sources: map[string]string{"args.name": "user"},
+		},
+		{
+			name: "hosts replace a lower hostlookup",
+			layers: []*testLayer{
+				{name: "system", yaml: "hostlookup: consul web\nexec: [uptime]\n"},
+				{name: "user", yaml: "hosts: [dev-1]\n"},
+			},
+			want:    "hosts: [dev-1]\nexec: [uptime]\n",
+			sources: map[string]string{"hosts": "user", "exec": "system"},
+		},
+		{
+			name: "a hostlookup replaces lower hosts",
+			layers: []*testLayer{
+				{name: "system", yaml: "hosts: [a, b]\nexec: [uptime]\n"},
+				{name: "team", yaml: "hostlookup: consul web\n"},
+			},
+			want:    "hostlookup: consul web\nexec: [uptime]\n",
+			sources: map[string]string{"hostlookup": "team", "exec": "system"},
+		},
+		{
+			name: "a folder only passes on its defaults",
+			layers: []*testLayer{
+				{name: "fleet", folder: true, yaml: "hosts: [a]\noverrides:\n  user: deploy\nexec: [ignored]\nhelp:\n  short: fleet recipes\n"},
+				{name: "recipe", yaml: "exec: [uptime]\n"},
+			},
+			want:    "hosts: [a]\noverrides:\n  user: deploy\nexec: [uptime]\n",
+			sources: map[string]string{"hosts": "fleet", "overrides.user": "fleet", "exec": "recipe"},
+		},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "blade-layers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers := writeLayers(t, dir, test.layers)
+
+		merged, sources, err := mergeLayers(layers)
+		os.RemoveAll(dir)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		var want map[interface{}]interface{}
+		if err := yaml.Unmarshal([]byte(test.want), &want); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(merged, want) {
+			t.Errorf("%s: merged %s, expected %s", test.name, FormatYamlValue(merged), FormatYamlValue(want))
+		}
+
+		got := make(map[string]string)
+		for path, layer := range sources {
+			got[path] = layer.Name
+		}
+		if !reflect.DeepEqual(got, test.sources) {
+			t.Errorf("%s: sources %v, expected %v", test.name, got, test.sources)
+		}
+	}
+}
+
+func TestLoadLayeredRecipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blade-layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layers := writeLayers(t, dir, []*testLayer{
+		{name: "system", yaml: "hostlookup: consul web\noverrides:\n  concurrency: 5\nexec:\n  - run: echo system\n    on_failure: ignore\n"},
+		{name: "user", yaml: "hosts: [dev-1, dev-2]\noverrides:\n  user: me\n"},
+	})
+	rec, err := LoadLayeredRecipe(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.HostLookup != "" || !reflect.DeepEqual(rec.Hosts, []string{"dev-1", "dev-2"}) {
+		t.Errorf("hosts %v and hostlookup %q, expected only the hosts of the user", rec.Hosts, rec.HostLookup)
+	}
+	if rec.Overrides.Concurrency != 5 || rec.Overrides.User != "me" {
+		t.Errorf("overrides %+v, expected the concurrency of the system and the user of the user", rec.Overrides)
+	}
+	if len(rec.Exec) != 1 || rec.Exec[0].Run != "echo system" || rec.Exec[0].OnFailure != OnFailureIgnore {
+		t.Errorf("exec %+v, expected the command of the system", rec.Exec)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hil/ast"
+)
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// ShellQuote quotes s for a POSIX shell so that it always ends up as a single word. Strings
+// that are already safe as a word are left as they are.
+func ShellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Within quotes a value is escaped for the quotes it sits in rather than quoted as a word,
+// since quoting it again would end the quotes around it.
+const (
+	funcQuoteSingle = "__shellquote_single"
+	funcQuoteDouble = "__shellquote_double"
+)
+
+// quoteFuncs are the functions that quote a value for the quote it sits within, if any.
+var quoteFuncs = map[byte]string{
+	0:    "shellquote",
+	'\'': funcQuoteSingle,
+	'"':  funcQuoteDouble,
+}
+
+// quoteSingle escapes s for within single quotes.
+func quoteSingle(s string) string {
+	return strings.Replace(s, "'", `'\''`, -1)
+}
+
+var doubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
+// quoteDouble escapes s for within double quotes.
+func quoteDouble(s string) string {
+	return doubleQuoteEscaper.Replace(s)
+}
+
+// QuoteInterpolations wraps every ${...} of a parsed command in shellquote so that values
+// can't break out into the shell command around them. An interpolation within single or
+// double quotes is escaped for those quotes instead. Interpolations that only use raw args,
+// or that are wrapped in raw() or shellquote() already, are left alone.
+func QuoteInterpolations(tree ast.Node, args BladeRecipeArguments) ast.Node {
+	output, ok := tree.(*ast.Output)
+	if !ok {
+		return tree
+	}
+
+	var quote byte
+	for i, expr := range output.Exprs {
+		if literal, ok := expr.(*ast.LiteralNode); ok {
+			if s, ok := literal.Value.(string); ok {
+				quote = scanQuotes(s, quote)
+			}
+			continue
+		}
+		if isRawExpr(expr, args) {
+			continue
+		}
+		output.Exprs[i] = &ast.Call{Func: quoteFuncs[quote], Args: []ast.Node{expr}, Posx: expr.Pos()}
+	}
+	return output
+}
+
+// scanQuotes returns the quote that is open at the end of s, given the quote that was open
+// at its start.
+func scanQuotes(s string, quote byte) byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && quote != '\'':
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		}
+	}
+	return quote
+}
+
+// isRawExpr reports whether an interpolation opted out of shell quoting.
+func isRawExpr(expr ast.Node, args BladeRecipeArguments) bool {
+	if call, ok := expr.(*ast.Call); ok && (call.Func == "raw" || call.Func == "shellquote") {
+		return true
+	}
+
+	accessed, raw := 0, 0
+	expr.Accept(func(n ast.Node) ast.Node {
+		if access, ok := n.(*ast.VariableAccess); ok {
+			accessed++
+			if arg, ok := args[access.Name]; ok && arg.Raw {
+				raw++
+			}
+		}
+		return n
+	})
+	return accessed > 0 && accessed == raw
+}
+
+// quotedInterpolations returns the ${...} of a command that sit within single or double
+// quotes, where they are escaped for those quotes unless they opted out of quoting.
+func quotedInterpolations(command string) []string {
+	var found []string
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\\' && quote != '\'':
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		case c == '$' && strings.HasPrefix(command[i:], "${"):
+			end := strings.Index(command[i:], "}")
+			if end < 0 {
+				return found
+			}
+			if quote != 0 {
+				found = append(found, command[i:i+end+1])
+			}
+			i += end
+		}
+	}
+	return found
+}
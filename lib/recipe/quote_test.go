@@ -0,0 +1,163 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/hil"
+	"github.com/hashicorp/hil/ast"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"web-1.example.com:22", "web-1.example.com:22"},
+		{"a/b_c@d%e+f=g,h", "a/b_c@d%e+f=g,h"},
+		{"", "''"},
+		{"hello world", "'hello world'"},
+		{"$(reboot)", "'$(reboot)'"},
+		{"it's", `'it'\''s'`},
+		{`"; rm -rf /"`, `'"; rm -rf /"'`},
+		{"a\nb", "'a\nb'"},
+	}
+	for _, test := range tests {
+		if got := ShellQuote(test.s); got != test.want {
+			t.Errorf("ShellQuote(%q) = %s, expected %s", test.s, got, test.want)
+		}
+	}
+}
+
+// quoteCommand interpolates value as v into command the way commands are run.
+func quoteCommand(t *testing.T, command, value string, args BladeRecipeArguments) string {
+	tree, err := hil.Parse(command)
+	if err != nil {
+		t.Fatalf("parsing %q: %s", command, err)
+	}
+	tree = QuoteInterpolations(tree, args)
+	result, err := hil.Eval(tree, NewEvalConfig(map[string]ast.Variable{
+		"v": {Type: ast.TypeString, Value: value},
+	}))
+	if err != nil {
+		t.Fatalf("evaluating %q: %s", command, err)
+	}
+	return result.Value.(string)
+}
+
+func TestQuoteInterpolations(t *testing.T) {
+	tests := []struct {
+		command, value, want string
+		args                 BladeRecipeArguments
+	}{
+		{command: "echo ${v}", value: "safe", want: "echo safe"},
+		{command: "echo ${v}", value: "a b", want: "echo 'a b'"},
+		{command: "echo '${v}'", value: "it's", want: `echo 'it'\''s'`},
+		{command: `echo "${v}"`, value: `say "$HOME"`, want: `echo "say \"\$HOME\""`},
+		{command: `echo "it's ${v}"`, value: "a b", want: `echo "it's a b"`},
+		{command: `echo \'${v}`, value: "a b", want: `echo \''a b'`},
+		{command: `echo '\' ${v}`, value: "a b", want: `echo '\' 'a b'`},
+		{command: "echo ${raw(v)}", value: "a b", want: "echo a b"},
+		{command: "echo ${shellquote(v)}", value: "a b", want: "echo 'a b'"},
+		{command: "echo ${v}", value: "a b", want: "echo a b", args: BladeRecipeArguments{"v": {Raw: true}}},
+	}
+	for _, test := range tests {
+		if got := quoteCommand(t, test.command, test.value, test.args); got != test.want {
+			t.Errorf("%s with %q = %s, expected %s", test.command, test.value, got, test.want)
+		}
+	}
+}
+
+// TestQuoteInterpolationsShell runs the quoted commands through sh to make sure a value
+// ends up exactly as it is, whatever quotes it sits in, without running any of it.
+func TestQuoteInterpolationsShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run the commands with")
+	}
+	dir, err := ioutil.TempDir("", "blade-quote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pwned := filepath.Join(dir, "pwned")
+
+	values := []string{
+		"x; touch " + pwned + "; echo",
+		"x'; touch " + pwned + "; echo '",
+		`x"; touch ` + pwned + `; echo "`,
+		"$(touch " + pwned + ")",
+		"`touch " + pwned + "`",
+		`back\slash \" \' $HOME`,
+	}
+	commands := []struct {
+		command, prefix, suffix string
+	}{
+		{command: "printf %s ${v}"},
+		{command: "printf %s '${v}'"},
+		{command: `printf %s "${v}"`},
+		{command: `printf %s "<${v}>"`, prefix: "<", suffix: ">"},
+		{command: `printf %s 'a '"${v}"' b'`, prefix: "a ", suffix: " b"},
+	}
+
+	for _, c := range commands {
+		for _, value := range values {
+			command := quoteCommand(t, c.command, value, nil)
+			out, err := exec.Command(sh, "-c", command).Output()
+			if err != nil {
+				t.Errorf("%s failed: %s", command, err)
+				continue
+			}
+			if want := c.prefix + value + c.suffix; string(out) != want {
+				t.Errorf("%s printed %q, expected %q", command, out, want)
+			}
+			if _, err := os.Stat(pwned); err == nil {
+				t.Fatalf("%s ran the value %q", command, value)
+			}
+		}
+	}
+}
+
+func TestQuotedInterpolations(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"echo ${a} ${b}", nil},
+		{"echo '${a}' ${b}", []string{"${a}"}},
+		{`echo "x ${a}" '${b}'`, []string{"${a}", "${b}"}},
+		{`echo \"${a}\"`, nil},
+		{`echo '\' ${a}`, nil},
+		{`echo "\"" ${a}`, nil},
+		{`echo "${a`, nil},
+	}
+	for _, test := range tests {
+		if got := quotedInterpolations(test.command); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("quotedInterpolations(%q) = %q, expected %q", test.command, got, test.want)
+		}
+	}
+}
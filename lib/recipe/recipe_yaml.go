@@ -22,41 +22,110 @@ SOFTWARE.
 package recipe
 
 import (
-	"log"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v1"
 )
 
 type BladeArgumentDetails struct {
-	Value string
-	Help  string
+	Value     string // <-- the default, a list is comma separated like a,b,c
+	Help      string
+	Type      string   // <-- string (default), int, bool, duration, list or enum
+	Choices   []string // <-- the allowed values of an enum or the items of a list
+	Pattern   string   // <-- a regular expression every string value must match
+	Required  bool     // <-- must be given on the command-line
+	Min       *int     // <-- the lowest int or the fewest list items
+	Max       *int     // <-- the highest int or the most list items
+	Sensitive bool     // <-- never show the value
+	Raw       bool     // <-- interpolate the value as is instead of shell quoted
 
-	flagValue string
-	argName   string
+	Secret *BladeArgumentSecret // <-- where to read a secret value from, it makes the argument sensitive
+
+	flag          *pflag.Flag
+	flagValue     string
+	intValue      int
+	boolValue     bool
+	durationValue time.Duration
+	listValue     []string
+	argName       string
+
+	secretOnce  sync.Once
+	secretValue string
+	secretFound bool
+	secretErr   error
+}
+
+// BladeArgumentSecret reads the value of an argument from elsewhere than the recipe or the
+// command-line. The sources are tried in the order below and the first with a value wins.
+type BladeArgumentSecret struct {
+	Env     string // <-- an environment variable
+	File    string // <-- a file, like ~/.blade/token
+	Command string // <-- a command, like pass show deploy/token
+	Prompt  bool   // <-- ask without echoing when there is a terminal
 }
 
 // AttachFlag allows you to pass in a Cobra command if you'd like to attach an override
 // flag in relation to this argument.
 func (a *BladeArgumentDetails) AttachFlag(cobraCommand *cobra.Command) {
-	cobraCommand.Flags().StringVarP(&a.flagValue, a.argName, "", "", a.Help+" (recipe flag)")
-}
-
-// FlagValue returns the applied flag which either came from a command-line override or
-// from the Recipe itself.
-func (a *BladeArgumentDetails) FlagValue() string {
-	// Precedence:
-	// 1. First use the flag override if provided.
-	// 2. Fallback to the recipe default value.
-	// 3. Otherwise Fatalf to indicate that a flag must be provided on the command-line since one is not inside the recipe.
-	if a.flagValue != "" {
-		return a.flagValue
+	usage := a.Help + " (recipe flag)"
+	if len(a.Choices) > 0 {
+		usage = fmt.Sprintf("%s (recipe flag, one of: %s)", a.Help, strings.Join(a.Choices, ", "))
+	}
+
+	// The recipe value is only shown as the default, which flag was set is checked later on.
+	defaultValue := a.Value
+	if a.sensitive() {
+		defaultValue = ""
+	}
+
+	flags := cobraCommand.Flags()
+	switch a.argType() {
+	case ArgInt:
+		n, _ := strconv.Atoi(defaultValue)
+		flags.IntVarP(&a.intValue, a.argName, "", n, usage)
+	case ArgBool:
+		b, _ := strconv.ParseBool(defaultValue)
+		flags.BoolVarP(&a.boolValue, a.argName, "", b, usage)
+	case ArgDuration:
+		d, _ := time.ParseDuration(defaultValue)
+		flags.DurationVarP(&a.durationValue, a.argName, "", d, usage)
+	case ArgList:
+		flags.StringSliceVarP(&a.listValue, a.argName, "", splitList(defaultValue), usage)
+	default:
+		flags.StringVarP(&a.flagValue, a.argName, "", defaultValue, usage)
+	}
+	a.flag = flags.Lookup(a.argName)
+}
+
+// value returns the flag override, the secret or else the recipe value, if any is set.
+func (a *BladeArgumentDetails) value() (string, bool, error) {
+	if a.flagGiven() {
+		if a.argType() == ArgList {
+			return strings.Join(a.listValue, ","), true, nil
+		}
+		return a.flag.Value.String(), true, nil
+	}
+	v, ok, err := a.secret()
+	if err != nil {
+		return "", false, err
+	}
+	if ok {
+		return v, true, nil
 	}
 	if a.Value != "" {
-		return a.Value
+		return a.Value, true, nil
 	}
-	log.Fatalf("%s: Flag \"--%s\" should be supplied as an argument since no default value is provided in the recipe\n", color.RedString("ERROR"), a.argName)
-	panic("Unreachable")
+	return "", false, nil
+}
+
+func (a *BladeArgumentDetails) flagGiven() bool {
+	return a.flag != nil && a.flag.Changed
 }
 
 // Name returns the argument name or what string is in the {{}} construct.
@@ -79,26 +148,190 @@ type BladeRecipeOverrides struct {
 }
 
 type BladeRecipeResilience struct {
-	WaitDuration           string
-	Retries                int
-	RetryBackoffStrategy   string
-	RetryBackoffMultiplier string // <-- this is a duration like 5s
+	WaitDuration           string // <-- the wait before the first retry like 500ms
+	Retries                *int   // <-- applies to both dials and commands
+	DialRetries            *int
+	CommandRetries         *int
+	MaxElapsedTime         string // <-- give up retrying after this duration like 5m
+	RetryBackoffStrategy   string // <-- constant, linear or exponential
+	RetryBackoffMultiplier string // <-- a duration like 5s for linear or a factor like 2 for exponential
 	FailBatch              bool
+	FailFast               bool // <-- cancel every host as soon as one host fails
+}
+
+type BladeRecipeBatch struct {
+	Size                 string // <-- a count like 5 or a percentage like 10%
+	Pause                string // <-- a duration like 30s
+	Confirm              bool
+	MaxFailures          *int
+	MaxFailurePercentage *int
+}
+
+type BladeRecipeCanary struct {
+	Count   int      // <-- run on the first N hosts first
+	Hosts   []string // <-- or on these named hosts first
+	Confirm bool     // <-- prompt before releasing the remaining hosts
+}
+
+type BladeRecipeTimeouts struct {
+	Dial    string // <-- a duration like 10s to establish the ssh connection
+	Command string // <-- a duration like 5m for every single command attempt
+	Host    string // <-- a duration like 15m for everything on one host
+	Run     string // <-- a duration like 1h for the whole recipe
+}
+
+type BladeRecipeInteraction struct {
+	Banner       string // <-- shown before anything is dialed like "This is PRODUCTION"
+	PromptBanner bool   // <-- ask to continue after the banner
+	PromptColor  string // <-- red (default), yellow, green, blue, magenta or cyan
+	ConfirmText  string // <-- require typing this, like the environment name, to continue
+}
+
+// Prompts reports whether the recipe asks for confirmation before it runs.
+func (i *BladeRecipeInteraction) Prompts() bool {
+	return i.PromptBanner || i.ConfirmText != ""
+}
+
+// Supported values of a command's on_failure policy.
+const (
+	OnFailureAbort    = "abort"    // <-- skip the remaining commands on this host (default)
+	OnFailureContinue = "continue" // <-- run the remaining commands, the host still fails
+	OnFailureIgnore   = "ignore"   // <-- run the remaining commands as if nothing failed
+)
+
+// BladeRecipeCommand is a single entry of the exec list, either a plain command string or
+// a mapping with the command under run and its settings alongside.
+type BladeRecipeCommand struct {
+	Run       string
+	OnFailure string `yaml:"on_failure"`
+
+	// err is why the entry couldn't be decoded, reported once the recipe is loaded.
+	err error
+}
+
+// SetYAML implements the yaml.Setter interface to accept both forms of an exec entry.
+// It never fails the decoding as the entry would silently be left out of the list,
+// instead an entry that isn't a command keeps the error for commandsErr.
+func (c *BladeRecipeCommand) SetYAML(tag string, value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		*c = BladeRecipeCommand{Run: v}
+	case map[interface{}]interface{}:
+		for k := range v {
+			if k != "run" && k != "on_failure" {
+				*c = BladeRecipeCommand{err: fmt.Errorf("unknown key %v, expected run or on_failure", k)}
+				return true
+			}
+		}
+		// Round trip through a type without SetYAML to decode the mapping form field by field.
+		type plainCommand BladeRecipeCommand
+		var plain plainCommand
+		b, err := yaml.Marshal(v)
+		if err == nil {
+			err = yaml.Unmarshal(b, &plain)
+		}
+		switch {
+		case err != nil:
+			*c = BladeRecipeCommand{err: err}
+		case plain.Run == "":
+			*c = BladeRecipeCommand{err: fmt.Errorf("has no run")}
+		default:
+			*c = BladeRecipeCommand(plain)
+		}
+	case []interface{}:
+		*c = BladeRecipeCommand{err: fmt.Errorf("must be a command or a mapping with run, not a list")}
+	case nil:
+		// Left empty, which planning reports.
+		*c = BladeRecipeCommand{}
+	default:
+		// Other scalars are commands too, like true or false.
+		*c = BladeRecipeCommand{Run: fmt.Sprint(value)}
+	}
+	return true
+}
+
+// commandsErr returns the first command of the recipe that couldn't be decoded.
+func (rec *BladeRecipeYaml) commandsErr() error {
+	check := func(what string, exec []*BladeRecipeCommand) error {
+		for i, c := range exec {
+			if c != nil && c.err != nil {
+				return fmt.Errorf("%s command %d %s", what, i+1, c.err.Error())
+			}
+		}
+		return nil
+	}
+
+	if err := check("exec", rec.Exec); err != nil {
+		return err
+	}
+	if err := check("local_before", rec.LocalBefore); err != nil {
+		return err
+	}
+	if err := check("local_after", rec.LocalAfter); err != nil {
+		return err
+	}
+	for i, step := range rec.Steps {
+		if step == nil {
+			continue
+		}
+		if err := check(fmt.Sprintf("step %d exec", i+1), step.Exec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BladeRecipeStep is one named step of a recipe. Every step runs on all of its hosts
+// before the next step starts.
+type BladeRecipeStep struct {
+	Name          string
+	Exec          []*BladeRecipeCommand
+	Hosts         []string // <-- runs on these hosts instead of the recipe's hosts
+	HostFilter    string   // <-- or only on the recipe's hosts matching a glob like web-*
+	Concurrency   int
+	SleepAfter    string // <-- a duration like 30s to wait before the next step
+	ConfirmBefore bool   // <-- prompt before the step starts
+	When          string // <-- a remote command, the step only runs on hosts where it succeeds
+	Unless        string // <-- a remote command, the step is skipped on hosts where it succeeds
+	Creates       string // <-- a remote path, the step is skipped on hosts where it exists
+
+	Register       string // <-- keep the stdout of the step as ${steps.<register>.stdout} for later steps on the host
+	RegisterFormat string // <-- trim (default) or json to also get ${steps.<register>.json.<path>}
+	Promote        bool   // <-- share what the first host registered with the run as ${run.steps.<register>...}
+
+	Env   map[string]string // <-- merged over the env of the recipe
+	Cwd   string            // <-- replaces the cwd of the recipe
+	Shell string            // <-- replaces the shell of the recipe
 }
 
 type BladeRecipeYaml struct {
 	Args BladeRecipeArguments
 
-	Name     string
-	Filename string
+	Name     string `yaml:"-"` // <-- derived from the recipe's path
+	Filename string `yaml:"-"`
 
-	Hosts      []string
-	HostLookup string
-	Exec       []string
+	Hosts       []string
+	HostLookup  string
+	Inventory   map[string]map[string]string // <-- variables per host, used as ${host.vars.<name>}
+	Env         map[string]string            // <-- environment variables of every remote command
+	Cwd         string                       // <-- the folder every remote command runs in
+	Shell       string                       // <-- wraps every remote command, like bash -lc or sh -e
+	Exec        []*BladeRecipeCommand
+	LocalBefore []*BladeRecipeCommand `yaml:"local_before"` // <-- run on this machine before any host is dialed
+	LocalAfter  []*BladeRecipeCommand `yaml:"local_after"`  // <-- run on this machine once every host is done
+	Steps       []*BladeRecipeStep    // <-- named steps to run in order instead of exec
+	Stages      []*BladeRecipeStage   // <-- other recipes to run in order instead of exec
 
-	Help       *BladeRecipeHelp
-	Overrides  *BladeRecipeOverrides
-	Resilience *BladeRecipeResilience
+	Help        *BladeRecipeHelp
+	Aliases     []string // <-- other names the recipe's command answers to
+	Hidden      bool     // <-- leave the recipe out of the help, it still runs
+	Deprecated  string   // <-- a message shown in the help and whenever the recipe runs
+	Overrides   *BladeRecipeOverrides
+	Resilience  *BladeRecipeResilience
+	Batch       *BladeRecipeBatch
+	Canary      *BladeRecipeCanary
+	Timeouts    *BladeRecipeTimeouts
+	Interaction *BladeRecipeInteraction
 }
 
 // func (yc *BladeRecipeYaml) OverridesDefined() bool {
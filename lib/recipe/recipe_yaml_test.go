@@ -0,0 +1,95 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2018 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBladeRecipeCommandSetYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		exec string
+		want []BladeRecipeCommand
+		err  string
+	}{
+		{
+			name: "strings",
+			exec: "[uptime, \"echo hi\"]",
+			want: []BladeRecipeCommand{{Run: "uptime"}, {Run: "echo hi"}},
+		},
+		{
+			name: "other scalars",
+			exec: "[true, false, 42]",
+			want: []BladeRecipeCommand{{Run: "true"}, {Run: "false"}, {Run: "42"}},
+		},
+		{
+			name: "mappings",
+			exec: "\n  - run: make\n    on_failure: ignore\n  - run: true\n",
+			want: []BladeRecipeCommand{{Run: "make", OnFailure: OnFailureIgnore}, {Run: "true"}},
+		},
+		{
+			name: "empty is left to planning",
+			exec: "[~]",
+			want: []BladeRecipeCommand{{}},
+		},
+		{
+			name: "mapping without run",
+			exec: "\n  - uptime\n  - on_failure: ignore\n",
+			err:  "exec command 2 has no run",
+		},
+		{
+			name: "mapping with an unknown key",
+			exec: "\n  - run: make\n    onfailure: ignore\n",
+			err:  "exec command 1 unknown key onfailure",
+		},
+		{
+			name: "list",
+			exec: "[[a, b]]",
+			err:  "exec command 1 must be a command or a mapping with run",
+		},
+	}
+
+	for _, test := range tests {
+		rec, err := parseRecipeYaml([]byte("exec: " + test.exec))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, expected it to contain %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		var got []BladeRecipeCommand
+		for _, c := range rec.Exec {
+			got = append(got, *c)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decoded %+v, expected %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	isatty "github.com/mattn/go-isatty"
+)
+
+const redacted = "****"
+
+// secrets holds every sensitive value resolved so far, they are redacted from all output.
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// AddSecret makes sure the value, and the ways it ends up shell quoted, never gets printed.
+func AddSecret(value string) {
+	if value == "" {
+		return
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range []string{value, ShellQuote(value), quoteSingle(value), quoteDouble(value)} {
+		found := false
+		for _, existing := range secrets.values {
+			found = found || existing == v
+		}
+		if !found {
+			secrets.values = append(secrets.values, v)
+		}
+	}
+	// The longest first so a quoted secret is redacted as a whole.
+	sort.Slice(secrets.values, func(i, j int) bool {
+		return len(secrets.values[i]) > len(secrets.values[j])
+	})
+}
+
+// Redact replaces every secret within s.
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	return s
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+// RedactingWriter returns a writer that redacts secrets before writing to w. Secrets are only
+// redacted within a single write, which is what a log.Logger does for every line.
+func RedactingWriter(w io.Writer) io.Writer {
+	return &redactingWriter{w: w}
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sensitive reports whether the value of the argument must never be shown.
+func (a *BladeArgumentDetails) sensitive() bool {
+	return a.Sensitive || a.Secret != nil
+}
+
+// addSecret redacts the value of the argument, and every item of a list on its own too as
+// they are used one by one.
+func (a *BladeArgumentDetails) addSecret(value string) {
+	AddSecret(value)
+	if a.argType() == ArgList {
+		for _, item := range splitList(value) {
+			AddSecret(item)
+		}
+	}
+}
+
+// secret resolves the secret of the argument once, unless a flag overrides it anyway.
+func (a *BladeArgumentDetails) secret() (string, bool, error) {
+	if a.Secret == nil || a.flagGiven() {
+		return "", false, nil
+	}
+	a.secretOnce.Do(func() {
+		a.secretValue, a.secretFound, a.secretErr = a.Secret.resolve(a)
+		if a.secretFound {
+			a.addSecret(a.secretValue)
+		}
+	})
+	return a.secretValue, a.secretFound, a.secretErr
+}
+
+func (s *BladeArgumentSecret) resolve(a *BladeArgumentDetails) (string, bool, error) {
+	if s.Env != "" {
+		if v := os.Getenv(s.Env); v != "" {
+			return v, true, nil
+		}
+	}
+
+	if s.File != "" {
+		b, err := ioutil.ReadFile(expandHome(s.File))
+		switch {
+		case err == nil:
+			return strings.TrimRight(string(b), "\r\n"), true, nil
+		case !os.IsNotExist(err):
+			return "", false, fmt.Errorf("couldn't read secret file: %s", err.Error())
+		}
+	}
+
+	if s.Command != "" {
+		commandSlice := strings.Fields(s.Command)
+		var stderr bytes.Buffer
+		command := exec.Command(commandSlice[0], commandSlice[1:]...)
+		command.Stderr = &stderr
+		out, err := command.Output()
+		if err != nil {
+			return "", false, fmt.Errorf("secret command %q failed: %s %s", s.Command, err.Error(), strings.TrimSpace(stderr.String()))
+		}
+		if v := strings.TrimRight(string(out), "\r\n"); v != "" {
+			return v, true, nil
+		}
+	}
+
+	if s.Prompt && isatty.IsTerminal(os.Stdin.Fd()) {
+		label := a.Help
+		if label == "" {
+			label = a.argName
+		}
+		v, err := promptHidden(fmt.Sprintf("%s (--%s): ", label, a.argName))
+		if err != nil {
+			return "", false, err
+		}
+		if v != "" {
+			return v, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// Stdin is the one reader of os.Stdin every prompt reads from, so no prompt loses input
+// another one buffered.
+var Stdin = bufio.NewReader(os.Stdin)
+
+// promptHidden reads a line from the terminal with echo turned off.
+func promptHidden(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	line, err := readHidden(int(os.Stdin.Fd()), Stdin)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// expandHome expands a leading ~/ to the home folder of the current user.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(usr.HomeDir, path[2:])
+}
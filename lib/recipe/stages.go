@@ -0,0 +1,231 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hil"
+	"github.com/hashicorp/hil/ast"
+)
+
+// BladeRecipeStage runs another recipe, referenced by its dotted name, as one stage of a
+// composed recipe.
+type BladeRecipeStage struct {
+	Recipe      string            // <-- dotted name like arsenic.mail-server.linux
+	Hosts       []string          // <-- overrides the hosts of the staged recipe
+	Concurrency int               // <-- overrides the concurrency of the staged recipe
+	Args        map[string]string // <-- values may use ${args} of the parent recipe
+
+	target *BladeRecipeYaml
+}
+
+// Target returns the recipe this stage runs, nil until LinkStages has run.
+func (s *BladeRecipeStage) Target() *BladeRecipeYaml {
+	return s.target
+}
+
+// StageError is a stage of a recipe that LinkStages couldn't link, Stage counting from 0.
+type StageError struct {
+	Recipe *BladeRecipeYaml
+	Stage  int
+	msg    string
+}
+
+func (e *StageError) Error() string {
+	return e.msg
+}
+
+// LinkStages resolves every stage of the recipes against the recipes themselves keyed by
+// their Name. It fails on stages referencing unknown recipes and on cycles, since a recipe
+// that ends up running itself would never finish. The error is a *StageError.
+func LinkStages(recipes map[string]*BladeRecipeYaml) error {
+	var names []string
+	for name := range recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for i, stage := range recipes[name].Stages {
+			target, ok := recipes[stage.Recipe]
+			if !ok {
+				return &StageError{Recipe: recipes[name], Stage: i,
+					msg: fmt.Sprintf("Stage %d of recipe %s references unknown recipe %q", i+1, name, stage.Recipe)}
+			}
+			stage.target = target
+		}
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*BladeRecipeYaml]int)
+
+	var visit func(rec *BladeRecipeYaml, path []string) error
+	visit = func(rec *BladeRecipeYaml, path []string) error {
+		path = append(path, rec.Name)
+		state[rec] = visiting
+		for i, stage := range rec.Stages {
+			switch state[stage.target] {
+			case visiting:
+				// The stage that closes the cycle is the one to blame.
+				return &StageError{Recipe: rec, Stage: i,
+					msg: fmt.Sprintf("Recipe stages form a cycle: %s -> %s", strings.Join(path, " -> "), stage.target.Name)}
+			case visited:
+				continue
+			}
+			if err := visit(stage.target, path); err != nil {
+				return err
+			}
+		}
+		state[rec] = visited
+		return nil
+	}
+
+	for _, name := range names {
+		if state[recipes[name]] == 0 {
+			if err := visit(recipes[name], nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Apply derives the recipe run by this stage of parent. The stage's own hosts, concurrency
+// and args win, then the staged recipe's settings, and finally what parent has to offer:
+// its hosts and inventory when the staged recipe has none and the values of its args by name.
+func (s *BladeRecipeStage) Apply(parent *BladeRecipeYaml) (*BladeRecipeYaml, error) {
+	if s.target == nil {
+		return nil, fmt.Errorf("Stage recipe %q was never linked", s.Recipe)
+	}
+
+	child := *s.target
+
+	if len(s.Hosts) > 0 {
+		child.Hosts, child.HostLookup = s.Hosts, ""
+	} else if len(child.Hosts) == 0 && child.HostLookup == "" {
+		child.Hosts, child.HostLookup = parent.Hosts, parent.HostLookup
+	}
+
+	if child.Inventory == nil {
+		child.Inventory = parent.Inventory
+	}
+
+	var overrides BladeRecipeOverrides
+	if child.Overrides != nil {
+		overrides = *child.Overrides
+	}
+	if s.Concurrency > 0 {
+		overrides.Concurrency = s.Concurrency
+	}
+	child.Overrides = &overrides
+
+	vars, err := parent.Args.Variables()
+	if err != nil {
+		return nil, err
+	}
+
+	child.Args = make(BladeRecipeArguments)
+	for name, arg := range s.target.Args {
+		child.Args[name] = arg.copy(name)
+	}
+	// Parent args are passed along even when this stage doesn't use them so they reach
+	// any stages further down.
+	for name, arg := range parent.Args {
+		v, ok, err := arg.value()
+		if err != nil {
+			return nil, fmt.Errorf("Flag \"--%s\": %s", name, err.Error())
+		}
+		if !ok {
+			continue
+		}
+		if _, ok := child.Args[name]; !ok {
+			child.Args[name] = arg.copy(name)
+		}
+		child.Args[name].supply(v)
+	}
+	for name, raw := range s.Args {
+		v, err := interpolate(raw, vars)
+		if err != nil {
+			return nil, fmt.Errorf("Stage %s arg %q: %s", s.Recipe, name, err.Error())
+		}
+		if _, ok := child.Args[name]; !ok {
+			child.Args[name] = &BladeArgumentDetails{argName: name}
+		}
+		child.Args[name].supply(v)
+	}
+
+	return &child, nil
+}
+
+// copy returns the definition of the argument without any flag attached to it.
+func (a *BladeArgumentDetails) copy(name string) *BladeArgumentDetails {
+	return &BladeArgumentDetails{
+		Value:     a.Value,
+		Help:      a.Help,
+		Type:      a.Type,
+		Choices:   a.Choices,
+		Pattern:   a.Pattern,
+		Required:  a.Required,
+		Min:       a.Min,
+		Max:       a.Max,
+		Sensitive: a.Sensitive,
+		Raw:       a.Raw,
+		Secret:    a.Secret,
+		argName:   name,
+	}
+}
+
+// supply sets the value of an argument on behalf of a parent recipe, which satisfies required
+// and takes the place of any secret, while keeping the value sensitive.
+func (a *BladeArgumentDetails) supply(v string) {
+	a.Sensitive = a.sensitive()
+	a.Value = v
+	a.Required = false
+	a.Secret = nil
+}
+
+// interpolate evaluates the ${...} expressions of s against the given variables.
+func interpolate(s string, vars map[string]ast.Variable) (string, error) {
+	tree, err := hil.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := hil.Eval(tree, NewEvalConfig(vars))
+	if err != nil {
+		return "", err
+	}
+	if list, ok := result.Value.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return fmt.Sprint(result.Value), nil
+}
@@ -0,0 +1,138 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"strings"
+	"testing"
+)
+
+// stagedRecipes builds recipes by name with the recipes their stages run.
+func stagedRecipes(stages map[string][]string) map[string]*BladeRecipeYaml {
+	recipes := make(map[string]*BladeRecipeYaml)
+	for name, targets := range stages {
+		rec := &BladeRecipeYaml{Name: name}
+		for _, target := range targets {
+			rec.Stages = append(rec.Stages, &BladeRecipeStage{Recipe: target})
+		}
+		recipes[name] = rec
+	}
+	return recipes
+}
+
+func TestLinkStages(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages map[string][]string
+		err    string
+		// at is the recipe and the index of the stage the error is about.
+		at    string
+		stage int
+	}{
+		{
+			name: "no stages",
+			stages: map[string][]string{
+				"a": nil,
+			},
+		},
+		{
+			name: "chain and diamond",
+			stages: map[string][]string{
+				"deploy":  {"drain", "release", "undrain"},
+				"release": {"drain", "undrain"},
+				"drain":   nil,
+				"undrain": nil,
+			},
+		},
+		{
+			name: "same recipe twice",
+			stages: map[string][]string{
+				"twice": {"hello", "hello"},
+				"hello": nil,
+			},
+		},
+		{
+			name: "unknown recipe",
+			stages: map[string][]string{
+				"a": {"b", "missing"},
+				"b": nil,
+			},
+			err:   `Stage 2 of recipe a references unknown recipe "missing"`,
+			at:    "a",
+			stage: 1,
+		},
+		{
+			name: "itself",
+			stages: map[string][]string{
+				"a": {"a"},
+			},
+			err: "cycle: a -> a",
+			at:  "a",
+		},
+		{
+			name: "indirect cycle",
+			stages: map[string][]string{
+				"a": {"b"},
+				"b": {"c"},
+				"c": {"a"},
+			},
+			err: "cycle: a -> b -> c -> a",
+			at:  "c",
+		},
+		{
+			name: "cycle below a recipe outside it",
+			stages: map[string][]string{
+				"a": {"b"},
+				"b": {"c"},
+				"c": {"b"},
+			},
+			err: "cycle: a -> b -> c -> b",
+			at:  "c",
+		},
+	}
+
+	for _, test := range tests {
+		recipes := stagedRecipes(test.stages)
+		err := LinkStages(recipes)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+				continue
+			}
+			for name, rec := range recipes {
+				for i, stage := range rec.Stages {
+					if stage.Target() != recipes[stage.Recipe] {
+						t.Errorf("%s: stage %d of %s isn't linked to %s", test.name, i+1, name, stage.Recipe)
+					}
+				}
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, expected it to contain %q", test.name, err, test.err)
+			continue
+		}
+		if stageErr, ok := err.(*StageError); !ok || stageErr.Recipe.Name != test.at || stageErr.Stage != test.stage {
+			t.Errorf("%s: error %#v, expected it to be about stage %d of %s", test.name, err, test.stage, test.at)
+		}
+	}
+}
@@ -33,12 +33,18 @@ func LoadRecipeYaml(path string) (*BladeRecipeYaml, error) {
 		// TODO: errors.Wrap
 		return nil, err
 	}
+	return parseRecipeYaml(b)
+}
 
+func parseRecipeYaml(b []byte) (*BladeRecipeYaml, error) {
 	var rec BladeRecipeYaml
-	err = yaml.Unmarshal(b, &rec)
+	err := yaml.Unmarshal(b, &rec)
 	if err != nil {
 		return nil, err
 	}
+	if err := rec.commandsErr(); err != nil {
+		return nil, err
+	}
 
 	// Each argument needs to capture it's arg name for later processing.
 	if len(rec.Args) > 0 {
@@ -47,17 +53,39 @@ func LoadRecipeYaml(path string) (*BladeRecipeYaml, error) {
 		}
 	}
 
-	if rec.Help == nil {
-		rec.Help = &BladeRecipeHelp{}
+	rec.ApplyDefaults()
+
+	return &rec, nil
+}
+
+// ApplyDefaults fills in every optional block the recipe left out, so that recipes built
+// in code can be planned like parsed ones.
+func (yc *BladeRecipeYaml) ApplyDefaults() {
+	if yc.Help == nil {
+		yc.Help = &BladeRecipeHelp{}
 	}
 
-	if rec.Overrides == nil {
-		rec.Overrides = &BladeRecipeOverrides{}
+	if yc.Overrides == nil {
+		yc.Overrides = &BladeRecipeOverrides{}
 	}
 
-	if rec.Resilience == nil {
-		rec.Resilience = &BladeRecipeResilience{}
+	if yc.Resilience == nil {
+		yc.Resilience = &BladeRecipeResilience{}
 	}
 
-	return &rec, nil
+	if yc.Batch == nil {
+		yc.Batch = &BladeRecipeBatch{}
+	}
+
+	if yc.Canary == nil {
+		yc.Canary = &BladeRecipeCanary{}
+	}
+
+	if yc.Timeouts == nil {
+		yc.Timeouts = &BladeRecipeTimeouts{}
+	}
+
+	if yc.Interaction == nil {
+		yc.Interaction = &BladeRecipeInteraction{}
+	}
 }
@@ -0,0 +1,611 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hil"
+	"github.com/hashicorp/hil/ast"
+	yaml "gopkg.in/yaml.v1"
+)
+
+// Problem is a single mistake found by ValidateRecipeFile.
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+	Warning bool // <-- likely a mistake, but the recipe still works
+}
+
+func (p *Problem) String() string {
+	if p.Warning {
+		return fmt.Sprintf("%s:%d: warning: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// StageProblem reports an error of LinkStages at the line of the stage it's about.
+func StageProblem(err error) *Problem {
+	stageErr, ok := err.(*StageError)
+	if !ok {
+		return &Problem{Line: 1, Message: err.Error()}
+	}
+	problem := &Problem{File: stageErr.Recipe.Filename, Line: 1, Message: err.Error()}
+	if b, err := ioutil.ReadFile(problem.File); err == nil {
+		problem.Line = indexYamlLines(b).lineOf(fmt.Sprintf("stages[%d].recipe", stageErr.Stage))
+	}
+	return problem
+}
+
+// ValidateRecipeFile strictly checks the recipe at path. Unlike LoadRecipeYaml it reports
+// unknown keys, values of the wrong type and arguments that don't line up with the
+// commands. When the file is an overlay of lower layers the recipe they make up together
+// is checked. An error is only returned when the file can't be read.
+func ValidateRecipeFile(path string, lower ...*Layer) ([]*Problem, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &validator{file: path, lines: indexYamlLines(b)}
+
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		v.report(yamlErrorLine(err), "%s", err.Error())
+		return v.problems, nil
+	}
+	if raw == nil {
+		v.report(1, "recipe is empty")
+		return v.problems, nil
+	}
+
+	v.checkValue("", raw, reflect.TypeOf(BladeRecipeYaml{}))
+
+	var rec BladeRecipeYaml
+	if err := yaml.Unmarshal(b, &rec); err != nil {
+		v.report(yamlErrorLine(err), "%s", err.Error())
+		return v.problems, nil
+	}
+	for _, layer := range lower {
+		if !layer.Folder {
+			continue
+		}
+		if folder, err := LoadFolderYaml(layer.File); err == nil {
+			if v.inheritedArgs == nil {
+				v.inheritedArgs = make(map[string]bool)
+			}
+			for name := range folder.Args {
+				v.inheritedArgs[name] = true
+			}
+		}
+	}
+	if len(lower) == 0 {
+		v.checkRecipe(&rec)
+	} else if layered, err := LoadLayeredRecipe(append(lower, &Layer{File: path})); err != nil {
+		v.report(1, "%s", err.Error())
+	} else {
+		v.checkRecipe(layered)
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+	return v.problems, nil
+}
+
+type validator struct {
+	file     string
+	lines    yamlLines
+	problems []*Problem
+
+	// inheritedArgs are declared by folders, which don't know whether a recipe uses them.
+	inheritedArgs map[string]bool
+}
+
+func (v *validator) report(line int, format string, a ...interface{}) {
+	v.problems = append(v.problems, &Problem{
+		File:    v.file,
+		Line:    line,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (v *validator) warn(line int, format string, a ...interface{}) {
+	v.report(line, format, a...)
+	v.problems[len(v.problems)-1].Warning = true
+}
+
+// checkValue compares a generically decoded yaml value against the Go type it is meant for.
+func (v *validator) checkValue(path string, value interface{}, t reflect.Type) {
+	if value == nil {
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// An exec entry may also be a plain command string.
+	if t == reflect.TypeOf(BladeRecipeCommand{}) {
+		if _, ok := value.(string); ok {
+			return
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			v.wrongType(path, value, "a mapping")
+			return
+		}
+		fields := yamlFields(t)
+		for _, key := range sortedKeys(m) {
+			field, ok := fields[key]
+			if !ok {
+				v.unknownKey(joinPath(path, key), key, fields)
+				continue
+			}
+			v.checkValue(joinPath(path, key), m[key], field.Type)
+		}
+	case reflect.Map:
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			v.wrongType(path, value, "a mapping")
+			return
+		}
+		for _, key := range sortedKeys(m) {
+			v.checkValue(joinPath(path, key), m[key], t.Elem())
+		}
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			v.wrongType(path, value, "a list")
+			return
+		}
+		for i, item := range items {
+			v.checkValue(fmt.Sprintf("%s[%d]", path, i), item, t.Elem())
+		}
+	case reflect.String:
+		switch value.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			v.wrongType(path, value, "a string")
+		}
+	case reflect.Int:
+		switch value.(type) {
+		case int, int64:
+		default:
+			v.wrongType(path, value, "a whole number")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			v.wrongType(path, value, "true or false")
+		}
+	}
+}
+
+func (v *validator) wrongType(path string, value interface{}, expected string) {
+	v.report(v.lines.lineOf(path), "%s must be %s but is %s", path, expected, describeYamlValue(value))
+}
+
+func (v *validator) unknownKey(path, key string, fields map[string]reflect.StructField) {
+	message := fmt.Sprintf("unknown key %q", key)
+	if suggestion := closestKey(key, fields); suggestion != "" {
+		message += fmt.Sprintf(", did you mean %q?", suggestion)
+	}
+	v.report(v.lines.lineOf(path), "%s", message)
+}
+
+var argSubstitutions = regexp.MustCompile(`\${.*?}`)
+
+// checkRecipe looks for mistakes that are only visible once the recipe is decoded.
+func (v *validator) checkRecipe(rec *BladeRecipeYaml) {
+	v.checkArgs(rec.Args)
+
+	used := make(map[string]bool)
+	isLocalVariable := func(name string) bool {
+		return IsRunVariable(name) || strings.HasPrefix(name, PromotedPrefix)
+	}
+	v.checkCommands(rec, "local_before", rec.LocalBefore, used, isLocalVariable)
+	v.checkCommands(rec, "local_after", rec.LocalAfter, used, isLocalVariable)
+
+	if len(rec.Stages) > 0 {
+		v.checkStages(rec)
+		return
+	}
+
+	if len(rec.Hosts) == 0 && rec.HostLookup == "" {
+		v.report(1, "recipe has neither hosts nor hostlookup")
+	}
+
+	switch {
+	case len(rec.Exec) > 0 && len(rec.Steps) > 0:
+		v.report(v.lines.lineOf("steps"), "a recipe can't have both exec and steps")
+	case len(rec.Exec) == 0 && len(rec.Steps) == 0:
+		v.report(v.lines.lineOf("exec"), "exec has no commands")
+	}
+
+	// Steps can use what the steps before them registered, while what was promoted may come
+	// from an earlier stage of a recipe this one is staged in.
+	registers := NewRegisters()
+	isStepVariable := func(name string) bool {
+		if strings.HasPrefix(name, StepsPrefix) {
+			return registers.Knows(name)
+		}
+		return IsHostVariable(name) || strings.HasPrefix(name, PromotedPrefix)
+	}
+	v.checkCommands(rec, "exec", rec.Exec, used, isStepVariable)
+	for i, step := range rec.Steps {
+		path := fmt.Sprintf("steps[%d]", i)
+		if len(step.Exec) == 0 {
+			v.report(v.lines.lineOf(path), "step %d has no commands", i+1)
+		}
+		v.checkCommands(rec, path+".exec", step.Exec, used, isStepVariable)
+		for _, guard := range []struct{ key, command string }{{"when", step.When}, {"unless", step.Unless}, {"creates", step.Creates}} {
+			if guard.command != "" {
+				v.checkCommand(rec, path+"."+guard.key, fmt.Sprintf("step %d %s", i+1, guard.key), guard.command, used, isStepVariable)
+			}
+		}
+		if step.Register != "" {
+			if registers.Registered(step.Register) {
+				v.report(v.lines.lineOf(path+".register"), "step %d registers %q which an earlier step registers already", i+1, step.Register)
+			}
+			registers.Add(step.Register, step.Promote)
+		}
+	}
+
+	for name := range rec.Args {
+		if !used[name] && !v.inheritedArgs[name] {
+			v.report(v.lines.lineOf(joinPath("args", name)), "argument %q is declared but never used", name)
+		}
+	}
+}
+
+// checkArgs checks the definition of every argument.
+func (v *validator) checkArgs(args BladeRecipeArguments) {
+	var names []string
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := args[name].check(); err != nil {
+			v.report(v.lines.lineOf(joinPath("args", name)), "argument %q: %s", name, err.Error())
+		}
+	}
+}
+
+// checkCommands checks the commands of an exec list and collects the args they use, where
+// builtin tells the variables the commands may use without declaring them.
+func (v *validator) checkCommands(rec *BladeRecipeYaml, path string, exec []*BladeRecipeCommand, used map[string]bool, builtin func(string) bool) {
+	for i, c := range exec {
+		v.checkCommand(rec, fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("command %d", i+1), c.Run, used, builtin)
+	}
+}
+
+// checkCommand checks a single command, which is called what in the problems it reports.
+func (v *validator) checkCommand(rec *BladeRecipeYaml, path, what, command string, used map[string]bool, builtin func(string) bool) {
+	if strings.TrimSpace(command) == "" {
+		v.report(v.lines.lineOf(path), "%s is empty", what)
+		return
+	}
+	if !argSubstitutions.MatchString(command) {
+		return
+	}
+	for _, quoted := range quotedInterpolations(command) {
+		tree, err := hil.Parse(quoted)
+		if err != nil {
+			continue
+		}
+		if output, ok := tree.(*ast.Output); ok && len(output.Exprs) == 1 {
+			tree = output.Exprs[0]
+		}
+		if isRawExpr(tree, rec.Args) {
+			v.warn(v.lines.lineOf(path), "%s uses %s within quotes without escaping it, a quote in its value ends the quotes around it", what, quoted)
+		}
+	}
+
+	tree, err := hil.Parse(command)
+	if err != nil {
+		v.report(v.lines.lineOf(path), "%s has an invalid interpolation: %s", what, err.Error())
+		return
+	}
+	tree.Accept(func(n ast.Node) ast.Node {
+		if call, ok := n.(*ast.Call); ok && !isFunc(call.Func) {
+			v.report(v.lines.lineOf(path), "%s calls unknown function %s()", what, call.Func)
+		}
+		access, ok := n.(*ast.VariableAccess)
+		if !ok {
+			return n
+		}
+		used[access.Name] = true
+		if _, declared := rec.Args[access.Name]; !declared && !builtin(access.Name) {
+			v.report(v.lines.lineOf(path), "%s uses ${%s} which is not declared under args", what, access.Name)
+		}
+		return n
+	})
+}
+
+// checkStages checks a composed recipe, whose args flow on to its stages and may be used
+// by any of them so unused args can't be told apart here.
+func (v *validator) checkStages(rec *BladeRecipeYaml) {
+	if len(rec.Exec) > 0 {
+		v.report(v.lines.lineOf("exec"), "a recipe with stages can't have exec commands of its own")
+	}
+
+	for i, stage := range rec.Stages {
+		path := fmt.Sprintf("stages[%d]", i)
+		if stage.Recipe == "" {
+			v.report(v.lines.lineOf(path), "stage %d has no recipe", i+1)
+		}
+		for _, name := range sortedArgNames(stage.Args) {
+			tree, err := hil.Parse(stage.Args[name])
+			if err != nil {
+				v.report(v.lines.lineOf(path+".args."+name), "stage %d arg %q has an invalid interpolation: %s", i+1, name, err.Error())
+				continue
+			}
+			tree.Accept(func(n ast.Node) ast.Node {
+				if call, ok := n.(*ast.Call); ok && !isFunc(call.Func) {
+					v.report(v.lines.lineOf(path+".args."+name), "stage %d arg %q calls unknown function %s()", i+1, name, call.Func)
+				}
+				if access, ok := n.(*ast.VariableAccess); ok {
+					if _, declared := rec.Args[access.Name]; !declared {
+						v.report(v.lines.lineOf(path+".args."+name), "stage %d arg %q uses ${%s} which is not declared under args", i+1, name, access.Name)
+					}
+				}
+				return n
+			})
+		}
+	}
+}
+
+func isFunc(name string) bool {
+	_, ok := Funcs()[name]
+	return ok
+}
+
+func sortedArgNames(args map[string]string) []string {
+	var names []string
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// yamlFields maps the yaml keys of a struct to its fields, following the same lowercase
+// naming rule that yaml.v1 applies.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		key := strings.ToLower(field.Name)
+		if tag := strings.Split(field.Tag.Get("yaml"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			key = tag
+		}
+		fields[key] = field
+	}
+	return fields
+}
+
+// closestKey suggests the known key a typo was most likely meant to be.
+func closestKey(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for candidate := range fields {
+		if d := levenshtein(key, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	if bestDistance > 2 {
+		return ""
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func describeYamlValue(value interface{}) string {
+	switch value.(type) {
+	case map[interface{}]interface{}:
+		return "a mapping"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("the string %q", value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func sortedKeys(m map[interface{}]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var yamlErrorLinePattern = regexp.MustCompile(`line (\d+):`)
+
+// yamlErrorLine extracts the line of a yaml.v1 syntax error, which it counts from zero.
+func yamlErrorLine(err error) int {
+	if m := yamlErrorLinePattern.FindStringSubmatch(err.Error()); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			return n + 1
+		}
+	}
+	return 1
+}
+
+// yamlLines maps key paths like overrides.port or exec[2] to the line they are defined on,
+// since yaml.v1 doesn't keep track of where anything was decoded from.
+type yamlLines map[string]int
+
+// lineOf returns the line of path, or of its nearest parent that has one.
+func (l yamlLines) lineOf(path string) int {
+	for path != "" {
+		if line, ok := l[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 1
+}
+
+var yamlKeyPattern = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s:#'"\[\]{}-][^:#]*?)\s*:(\s+(.*))?$`)
+
+// indexYamlLines is a deliberately small scanner of block style yaml that is good enough
+// to point at the line of a key or list item, it is not a yaml parser.
+func indexYamlLines(src []byte) yamlLines {
+	type frame struct {
+		indent int
+		path   string
+		items  int
+		item   bool
+		block  bool
+	}
+
+	lines := make(yamlLines)
+	var stack []*frame
+
+	for n, text := range strings.Split(string(src), "\n") {
+		lineNumber := n + 1
+		content := strings.TrimLeft(text, " ")
+		indent := len(text) - len(content)
+		content = strings.TrimRight(content, " \t\r")
+
+		// Lines of a block scalar belong to the key that started it.
+		if len(stack) > 0 && stack[len(stack)-1].block {
+			if content == "" || indent > stack[len(stack)-1].indent {
+				continue
+			}
+		}
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+
+		for {
+			isItem := content == "-" || strings.HasPrefix(content, "- ")
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.indent > indent || (top.indent == indent && (!isItem || top.item)) {
+					stack = stack[:len(stack)-1]
+					continue
+				}
+				break
+			}
+
+			parent := &frame{indent: -1}
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+
+			if isItem {
+				path := fmt.Sprintf("%s[%d]", parent.path, parent.items)
+				parent.items++
+				if _, ok := lines[path]; !ok {
+					lines[path] = lineNumber
+				}
+				stack = append(stack, &frame{indent: indent, path: path, item: true})
+
+				rest := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+				if rest == "" {
+					break
+				}
+				// What follows the dash is nested within the item.
+				indent += len(content) - len(rest)
+				content = rest
+				continue
+			}
+
+			m := yamlKeyPattern.FindStringSubmatch(content)
+			if m == nil {
+				break
+			}
+			key := strings.Trim(m[1], `"'`)
+			path := joinPath(parent.path, key)
+			if _, ok := lines[path]; !ok {
+				lines[path] = lineNumber
+			}
+			value := m[3]
+			stack = append(stack, &frame{
+				indent: indent,
+				path:   path,
+				block:  strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"),
+			})
+			break
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,109 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package recipe
+
+import "testing"
+
+func TestIndexYamlLines(t *testing.T) {
+	src := `# a comment
+hosts: ["web-1", "web-2"]
+overrides:
+  port: 2222
+  "user": deploy
+
+args:
+  name:
+    help: |
+      spans
+      lines: with a colon
+    value: x
+exec:
+  - echo one
+  - run: echo two
+    on_failure: ignore
+  -
+    run: echo three
+steps:
+  - name: drain
+    exec:
+      - drain
+  - name: restart
+`
+	lines := indexYamlLines([]byte(src))
+
+	tests := []struct {
+		path string
+		line int
+	}{
+		{"hosts", 2},
+		{"overrides", 3},
+		{"overrides.port", 4},
+		{"overrides.user", 5},
+		{"args.name", 8},
+		{"args.name.help", 9},
+		{"args.name.value", 12},
+		{"exec", 13},
+		{"exec[0]", 14},
+		{"exec[1]", 15},
+		{"exec[1].run", 15},
+		{"exec[1].on_failure", 16},
+		{"exec[2]", 17},
+		{"exec[2].run", 18},
+		{"steps[0].name", 20},
+		{"steps[0].exec[0]", 22},
+		{"steps[1].name", 23},
+	}
+	for _, test := range tests {
+		if line, ok := lines[test.path]; !ok || line != test.line {
+			t.Errorf("line of %s is %d, expected %d", test.path, line, test.line)
+		}
+	}
+
+	// Lines of a block scalar aren't keys of their own.
+	if _, ok := lines["args.name.lines"]; ok {
+		t.Errorf("indexed a line of a block scalar as a key")
+	}
+	if _, ok := lines["a comment"]; ok {
+		t.Errorf("indexed a comment as a key")
+	}
+}
+
+func TestLineOf(t *testing.T) {
+	lines := yamlLines{"exec": 3, "exec[1]": 5, "overrides": 7}
+
+	tests := []struct {
+		path string
+		line int
+	}{
+		{"exec[1]", 5},
+		{"exec[1].on_failure", 5},
+		{"exec[4]", 3},
+		{"overrides.port", 7},
+		{"hosts", 1},
+		{"", 1},
+	}
+	for _, test := range tests {
+		if line := lines.lineOf(test.path); line != test.line {
+			t.Errorf("lineOf(%q) = %d, expected %d", test.path, line, test.line)
+		}
+	}
+}
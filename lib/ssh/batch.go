@@ -0,0 +1,164 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deckarep/blade/lib/recipe"
+)
+
+// noFailureLimit means a rollout never aborts because of failed hosts.
+const noFailureLimit = -1
+
+// batchPolicy describes how hosts are rolled out in batches.
+type batchPolicy struct {
+	// size is the number of hosts per batch.
+	size int
+	// pause is the wait between two batches.
+	pause time.Duration
+	// confirm prompts before every batch after the first.
+	confirm bool
+	// maxFailures aborts the remaining batches once more hosts than this have failed.
+	maxFailures int
+}
+
+// resolveBatchPolicy applies the precedence: flag, then recipe, then default, where
+// the default is a single batch of every host with no failure limit.
+func resolveBatchPolicy(rec *recipe.BladeRecipeYaml, modifier *SessionModifier, totalHosts int) (*batchPolicy, error) {
+	batch := rec.Batch
+	if batch == nil {
+		batch = &recipe.BladeRecipeBatch{}
+	}
+	flags := modifier.FlagOverrides
+
+	policy := &batchPolicy{
+		size:        totalHosts,
+		confirm:     batch.Confirm || flags.BatchConfirm,
+		maxFailures: noFailureLimit,
+	}
+
+	size := batch.Size
+	if flags.BatchSize != "" {
+		size = flags.BatchSize
+	}
+	if size != "" {
+		n, err := parseCountOrPercentage(size, totalHosts)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid batch size %q: %s", size, err.Error())
+		}
+		// A percentage of a small fleet may round down to nothing, a batch must make progress.
+		if n < 1 {
+			n = 1
+		}
+		policy.size = n
+	}
+
+	if batch.Pause != "" {
+		pause, err := time.ParseDuration(batch.Pause)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid batch pause %q: %s", batch.Pause, err.Error())
+		}
+		policy.pause = pause
+	}
+	if flags.BatchPause > 0 {
+		policy.pause = flags.BatchPause
+	}
+
+	// FailBatch is the strictest threshold, a single failed host stops the rollout.
+	if rec.Resilience != nil && rec.Resilience.FailBatch {
+		policy.maxFailures = 0
+	}
+	if batch.MaxFailurePercentage != nil {
+		policy.maxFailures = *batch.MaxFailurePercentage * totalHosts / 100
+	}
+	if batch.MaxFailures != nil {
+		policy.maxFailures = *batch.MaxFailures
+	}
+	if flags.MaxFailurePercentage != nil {
+		policy.maxFailures = *flags.MaxFailurePercentage * totalHosts / 100
+	}
+	if flags.MaxFailures != nil {
+		policy.maxFailures = *flags.MaxFailures
+	}
+
+	return policy, nil
+}
+
+// split chunks the hosts into consecutive batches of at most size hosts.
+func (b *batchPolicy) split(hosts []*PlannedHost) [][]*PlannedHost {
+	var batches [][]*PlannedHost
+	for len(hosts) > 0 {
+		n := b.size
+		if n > len(hosts) {
+			n = len(hosts)
+		}
+		batches = append(batches, hosts[:n])
+		hosts = hosts[n:]
+	}
+	return batches
+}
+
+// describe summarizes how total hosts are rolled out under this policy.
+func (b *batchPolicy) describe(total int) string {
+	batches := 0
+	if b.size > 0 {
+		batches = (total + b.size - 1) / b.size
+	}
+	desc := fmt.Sprintf("%d batches of at most %d hosts", batches, b.size)
+	if b.pause > 0 {
+		desc += fmt.Sprintf(", pausing %s in between", b.pause)
+	}
+	if b.confirm {
+		desc += ", confirming each batch"
+	}
+	if b.maxFailures != noFailureLimit {
+		desc += fmt.Sprintf(", aborting after more than %d failed hosts", b.maxFailures)
+	}
+	return desc
+}
+
+// exceeded reports whether the failed hosts so far should abort the remaining batches.
+func (b *batchPolicy) exceeded(failed int) bool {
+	return b.maxFailures != noFailureLimit && failed > b.maxFailures
+}
+
+// parseCountOrPercentage parses either a plain count like 5 or a percentage of total like 10%.
+func parseCountOrPercentage(value string, total int) (int, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		pct, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || pct <= 0 || pct > 100 {
+			return 0, fmt.Errorf("a percentage must be between 1%% and 100%%")
+		}
+		return pct * total / 100, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("a count must be a positive number")
+	}
+	return n, nil
+}
@@ -0,0 +1,65 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import "testing"
+
+func TestParseCountOrPercentage(t *testing.T) {
+	tests := []struct {
+		value   string
+		total   int
+		want    int
+		wantErr bool
+	}{
+		{value: "5", total: 100, want: 5},
+		{value: " 5 ", total: 100, want: 5},
+		{value: "200", total: 10, want: 200},
+		{value: "10%", total: 100, want: 10},
+		{value: "25%", total: 10, want: 2},
+		{value: "1%", total: 10, want: 0},
+		{value: "100%", total: 7, want: 7},
+		{value: "0", total: 10, wantErr: true},
+		{value: "-3", total: 10, wantErr: true},
+		{value: "0%", total: 10, wantErr: true},
+		{value: "101%", total: 10, wantErr: true},
+		{value: "ten", total: 10, wantErr: true},
+		{value: "%", total: 10, wantErr: true},
+		{value: "", total: 10, wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseCountOrPercentage(test.value, test.total)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseCountOrPercentage(%q, %d) = %d, expected an error", test.value, test.total, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCountOrPercentage(%q, %d): unexpected error: %s", test.value, test.total, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseCountOrPercentage(%q, %d) = %d, expected %d", test.value, test.total, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"fmt"
+
+	"github.com/deckarep/blade/lib/recipe"
+)
+
+// canaryPolicy describes the hosts that must succeed before the rest of the fleet is released.
+type canaryPolicy struct {
+	// hosts are the canaries, empty when the session has no canary phase.
+	hosts []*PlannedHost
+	// confirm prompts before releasing the remaining hosts.
+	confirm bool
+}
+
+// resolveCanaryPolicy splits allHosts into canaries and the remaining hosts. Named canary
+// hosts win over a canary count and flags win over the recipe.
+func resolveCanaryPolicy(rec *recipe.BladeRecipeYaml, modifier *SessionModifier, allHosts []*PlannedHost, port int) (*canaryPolicy, []*PlannedHost, error) {
+	canary := rec.Canary
+	if canary == nil {
+		canary = &recipe.BladeRecipeCanary{}
+	}
+	flags := modifier.FlagOverrides
+
+	policy := &canaryPolicy{
+		confirm: canary.Confirm || flags.CanaryConfirm,
+	}
+
+	named := canary.Hosts
+	count := canary.Count
+	if flags.CanaryCount > 0 {
+		named, count = nil, flags.CanaryCount
+	}
+	if len(flags.CanaryHosts) > 0 {
+		named = flags.CanaryHosts
+	}
+
+	if len(named) == 0 {
+		if count >= len(allHosts) {
+			return nil, nil, fmt.Errorf("A canary of %d hosts leaves none of the %d hosts to release", count, len(allHosts))
+		}
+		policy.hosts = allHosts[:count]
+		return policy, allHosts[count:], nil
+	}
+
+	isCanary := make(map[string]bool)
+	for _, h := range named {
+		host, err := normalizeHost(h, port)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Couldn't parse canary host: %s", h)
+		}
+		isCanary[host] = true
+	}
+
+	var remaining []*PlannedHost
+	for _, h := range allHosts {
+		if isCanary[h.Host] {
+			policy.hosts = append(policy.hosts, h)
+			delete(isCanary, h.Host)
+		} else {
+			remaining = append(remaining, h)
+		}
+	}
+	for h := range isCanary {
+		return nil, nil, fmt.Errorf("Canary host %s is not one of the hosts of this session", h)
+	}
+
+	return policy, remaining, nil
+}
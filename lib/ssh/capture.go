@@ -0,0 +1,266 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/hashicorp/hil"
+	"github.com/hashicorp/hil/ast"
+)
+
+// Formats the stdout of a step can be registered in.
+const (
+	RegisterTrim = "trim"
+	RegisterJSON = "json"
+)
+
+var validRegisterName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// captures holds the values steps registered on every host during a run.
+type captures struct {
+	mu     sync.Mutex
+	byHost map[string]map[string]ast.Variable
+}
+
+func newCaptures() *captures {
+	return &captures{byHost: make(map[string]map[string]ast.Variable)}
+}
+
+// registerVars turns the stdout of a step into the variables it is registered as, which are
+// ${steps.<name>.stdout} trimmed and with the json format ${steps.<name>.json.<path>} for
+// every value of the document, like ${steps.info.json.version} or ${steps.info.json.tags.0}.
+func registerVars(prefix, format, stdout string) (map[string]ast.Variable, error) {
+	vars := map[string]ast.Variable{
+		prefix + ".stdout": {Type: ast.TypeString, Value: strings.TrimSpace(stdout)},
+	}
+	if format != RegisterJSON {
+		return vars, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(stdout))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("stdout isn't JSON: %s", err.Error())
+	}
+	flattenJSON(vars, prefix+".json", doc)
+	return vars, nil
+}
+
+func flattenJSON(vars map[string]ast.Variable, name string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			flattenJSON(vars, name+"."+k, item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenJSON(vars, name+"."+strconv.Itoa(i), item)
+		}
+	case json.Number:
+		if n, err := strconv.Atoi(v.String()); err == nil {
+			vars[name] = ast.Variable{Type: ast.TypeInt, Value: n}
+		} else if f, err := v.Float64(); err == nil {
+			vars[name] = ast.Variable{Type: ast.TypeFloat, Value: f}
+		}
+	case bool:
+		vars[name] = ast.Variable{Type: ast.TypeBool, Value: v}
+	case string:
+		vars[name] = ast.Variable{Type: ast.TypeString, Value: v}
+	case nil:
+		vars[name] = ast.Variable{Type: ast.TypeString, Value: ""}
+	}
+}
+
+// register keeps the stdout of a step on a host for the later steps on that host.
+func (p *Plan) register(step *PlannedStep, host *PlannedHost, stepResult *HostResult) error {
+	var stdout bytes.Buffer
+	for _, c := range stepResult.Commands {
+		stdout.WriteString(c.Stdout)
+	}
+	vars, err := registerVars(recipe.StepsPrefix+step.Register, step.RegisterFormat, stdout.String())
+	if err != nil {
+		return err
+	}
+
+	p.captures.mu.Lock()
+	hostVars := p.captures.byHost[host.key()]
+	if hostVars == nil {
+		hostVars = make(map[string]ast.Variable)
+		p.captures.byHost[host.key()] = hostVars
+	}
+	for k, v := range vars {
+		hostVars[k] = v
+	}
+	p.captures.mu.Unlock()
+	return nil
+}
+
+// promote shares what the step registered with the whole run once it ran on the hosts, taking
+// the first of them in plan order that registered it so the value doesn't depend on which host
+// finished first.
+func (p *Plan) promote(step *PlannedStep, hosts []*PlannedHost) {
+	prefix := recipe.StepsPrefix + step.Register + "."
+
+	p.captures.mu.Lock()
+	defer p.captures.mu.Unlock()
+	for _, h := range hosts {
+		hostVars := p.captures.byHost[h.key()]
+		if _, ok := hostVars[prefix+"stdout"]; !ok {
+			continue
+		}
+		vars := make(map[string]ast.Variable)
+		for k, v := range hostVars {
+			if strings.HasPrefix(k, prefix) {
+				vars[k] = v
+			}
+		}
+		p.run.promote(step.Register, vars)
+		return
+	}
+}
+
+// promote shares the variables a step registered with the whole run as ${run.steps.<name>...},
+// where the first batch to promote them wins.
+func (r *runInfo) promote(name string, vars map[string]ast.Variable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.promotedSteps[name] {
+		return
+	}
+	if r.promoted == nil {
+		r.promoted = make(map[string]ast.Variable)
+		r.promotedSteps = make(map[string]bool)
+	}
+	r.promotedSteps[name] = true
+	for k, v := range vars {
+		r.promoted["run."+k] = v
+	}
+}
+
+func (r *runInfo) promotedVars() map[string]ast.Variable {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	vars := make(map[string]ast.Variable, len(r.promoted))
+	for k, v := range r.promoted {
+		vars[k] = v
+	}
+	return vars
+}
+
+// runRegisters returns what the earlier stages of the run promoted, which the plan of the
+// session starts out with.
+func (s *Session) runRegisters() *recipe.Registers {
+	if s.registers == nil {
+		return recipe.NewRegisters()
+	}
+	return s.registers.Run()
+}
+
+// commands returns the commands of the step along with those of its guards.
+func (step *PlannedStep) commands() []string {
+	var commands []string
+	for _, c := range step.exec {
+		commands = append(commands, c.Run)
+	}
+	for _, g := range step.guards {
+		commands = append(commands, g.command)
+	}
+	return commands
+}
+
+// checkCaptured fails on a captured variable the commands use that no earlier step registered,
+// as it would silently be empty once running.
+func checkCaptured(commands []string, registers *recipe.Registers) error {
+	for _, command := range commands {
+		tree, err := hil.Parse(command)
+		if err != nil {
+			// Evaluating the command reports the error.
+			continue
+		}
+		var unknown string
+		tree.Accept(func(n ast.Node) ast.Node {
+			access, ok := n.(*ast.VariableAccess)
+			if ok && unknown == "" && recipe.IsCapturedVariable(access.Name) && !registers.Knows(access.Name) {
+				unknown = access.Name
+			}
+			return n
+		})
+		if unknown != "" {
+			if strings.HasPrefix(unknown, recipe.PromotedPrefix) {
+				return fmt.Errorf("${%s} refers to a step that no earlier step promotes", unknown)
+			}
+			return fmt.Errorf("${%s} refers to a step that no earlier step registers", unknown)
+		}
+	}
+	return nil
+}
+
+// capturedVars adds what was registered on the host and promoted to the run to vars, host being
+// nil for local commands. A captured variable the commands use that has no value, because
+// nothing ran yet while planning or the host skipped the step, is unknown while planning and
+// empty once running.
+func (p *Plan) capturedVars(vars map[string]ast.Variable, host *PlannedHost, exec []*recipe.BladeRecipeCommand) {
+	for k, v := range p.run.promotedVars() {
+		vars[k] = v
+	}
+	if p.captures != nil && host != nil {
+		p.captures.mu.Lock()
+		for k, v := range p.captures.byHost[host.key()] {
+			vars[k] = v
+		}
+		p.captures.mu.Unlock()
+	}
+
+	for _, c := range exec {
+		tree, err := hil.Parse(c.Run)
+		if err != nil {
+			// Evaluating the command reports the error.
+			continue
+		}
+		tree.Accept(func(n ast.Node) ast.Node {
+			access, ok := n.(*ast.VariableAccess)
+			if !ok || !recipe.IsCapturedVariable(access.Name) {
+				return n
+			}
+			if _, found := vars[access.Name]; found {
+				return n
+			}
+			if p.captures == nil {
+				vars[access.Name] = ast.Variable{Type: ast.TypeUnknown, Value: hil.UnknownValue}
+			} else {
+				vars[access.Name] = ast.Variable{Type: ast.TypeString, Value: ""}
+			}
+			return n
+		})
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"testing"
+
+	"github.com/deckarep/blade/lib/recipe"
+)
+
+func TestCheckCaptured(t *testing.T) {
+	registers := recipe.NewRegisters()
+	registers.Add("version", false)
+	registers.Add("build", true)
+
+	tests := []struct {
+		command   string
+		registers *recipe.Registers
+		wantErr   bool
+	}{
+		{command: "echo ${steps.version.stdout} ${steps.build.json.id}", registers: registers},
+		{command: "echo ${run.steps.build.stdout}", registers: registers},
+		{command: "echo ${upper(steps.version.stdout)}", registers: registers},
+		{command: "echo ${host.name} ${args}", registers: registers},
+		{command: "echo ${steps.vre.stdout}", registers: registers, wantErr: true},
+		{command: "echo ${run.steps.version.stdout}", registers: registers, wantErr: true},
+		{command: "echo ${default(steps.missing.stdout, \"x\")}", registers: registers, wantErr: true},
+		// Later recipes of the run only know what was promoted.
+		{command: "echo ${run.steps.build.stdout}", registers: registers.Run()},
+		{command: "echo ${steps.build.stdout}", registers: registers.Run(), wantErr: true},
+		{command: "echo ${steps.version.stdout}", registers: recipe.NewRegisters(), wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := checkCaptured([]string{test.command}, test.registers)
+		if test.wantErr && err == nil {
+			t.Errorf("%s: expected an error", test.command)
+		}
+		if !test.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.command, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"golang.org/x/crypto/ssh"
+)
+
+// Kinds of step guards.
+const (
+	guardWhen    = "when"    // <-- run the step only where the command succeeds
+	guardUnless  = "unless"  // <-- skip the step where the command succeeds
+	guardCreates = "creates" // <-- skip the step where the path exists
+)
+
+// stepGuard is a remote command that runs before the commands of a step and decides by its
+// exit status whether the step runs on a host at all.
+type stepGuard struct {
+	kind    string
+	command string
+}
+
+// planGuards collects the guards of a recipe step in the order they are checked.
+func planGuards(recStep *recipe.BladeRecipeStep) []*stepGuard {
+	var guards []*stepGuard
+	if recStep.Creates != "" {
+		path := recStep.Creates
+		// An interpolated path gets shell quoted when it's evaluated.
+		if !argSubstitutions.MatchString(path) {
+			path = quoteFolder(path)
+		}
+		guards = append(guards, &stepGuard{kind: guardCreates, command: "test -e " + path})
+	}
+	if recStep.When != "" {
+		guards = append(guards, &stepGuard{kind: guardWhen, command: recStep.When})
+	}
+	if recStep.Unless != "" {
+		guards = append(guards, &stepGuard{kind: guardUnless, command: recStep.Unless})
+	}
+	return guards
+}
+
+// guardsFor evaluates the guards of the step for a single host.
+func (p *Plan) guardsFor(step *PlannedStep, host *PlannedHost, batch int) ([]*stepGuard, error) {
+	if len(step.guards) == 0 {
+		return nil, nil
+	}
+	exec := make([]*recipe.BladeRecipeCommand, len(step.guards))
+	for i, g := range step.guards {
+		exec[i] = &recipe.BladeRecipeCommand{Run: g.command}
+	}
+	vars := p.hostVars(step, host, batch)
+	p.capturedVars(vars, host, exec)
+	commands, err := prepareCommands(p.recipe.Args, exec, vars)
+	if err != nil {
+		return nil, fmt.Errorf("Guard: %s", err.Error())
+	}
+	guards := make([]*stepGuard, len(commands))
+	for i, c := range commands {
+		guards[i] = &stepGuard{kind: step.guards[i].kind, command: c.Command}
+	}
+	return guards, nil
+}
+
+// checkGuards runs the guards on the host and returns why the step is skipped there, or an
+// empty reason when the step runs. A guard that can't be run at all fails the host.
+func (s *Session) checkGuards(ctx context.Context, client *ssh.Client, hostname string, guards []*stepGuard, env *remoteEnv) (string, error) {
+	for _, g := range guards {
+		err := newSingleExecution(s, client, hostname, g.command, 0, env).do(ctx)
+		if _, exited := err.(*ssh.ExitError); err != nil && !exited {
+			return "", fmt.Errorf("Guard %s `%s` couldn't run: %s", g.kind, g.command, err.Error())
+		}
+
+		if reason := g.skipReason(err == nil); reason != "" {
+			return reason, nil
+		}
+	}
+	return "", nil
+}
+
+// skipReason returns why the step is skipped given whether the guard succeeded, or an empty
+// reason when the guard lets the step run.
+func (g *stepGuard) skipReason(succeeded bool) string {
+	switch {
+	case g.kind == guardWhen && !succeeded:
+		return fmt.Sprintf("when `%s` failed", g.command)
+	case g.kind == guardUnless && succeeded:
+		return fmt.Sprintf("unless `%s` succeeded", g.command)
+	case g.kind == guardCreates && succeeded:
+		return fmt.Sprintf("%s exists", strings.TrimPrefix(g.command, "test -e "))
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/deckarep/blade/lib/recipe"
+)
+
+func TestPlanGuards(t *testing.T) {
+	tests := []struct {
+		name string
+		step *recipe.BladeRecipeStep
+		want []*stepGuard
+	}{
+		{
+			name: "none",
+			step: &recipe.BladeRecipeStep{},
+		},
+		{
+			name: "creates, when and unless in that order",
+			step: &recipe.BladeRecipeStep{When: "test -f /etc/app", Unless: "pgrep app", Creates: "/opt/app"},
+			want: []*stepGuard{
+				{kind: guardCreates, command: "test -e /opt/app"},
+				{kind: guardWhen, command: "test -f /etc/app"},
+				{kind: guardUnless, command: "pgrep app"},
+			},
+		},
+		{
+			name: "creates quotes the path",
+			step: &recipe.BladeRecipeStep{Creates: "/opt/my app"},
+			want: []*stepGuard{{kind: guardCreates, command: "test -e '/opt/my app'"}},
+		},
+		{
+			name: "creates keeps a leading ~/ working",
+			step: &recipe.BladeRecipeStep{Creates: "~/.installed"},
+			want: []*stepGuard{{kind: guardCreates, command: "test -e ~/.installed"}},
+		},
+		{
+			name: "creates leaves an interpolated path to be quoted when evaluated",
+			step: &recipe.BladeRecipeStep{Creates: "/opt/${version}"},
+			want: []*stepGuard{{kind: guardCreates, command: "test -e /opt/${version}"}},
+		},
+	}
+
+	for _, test := range tests {
+		if got := planGuards(test.step); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: planned %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStepGuardSkipReason(t *testing.T) {
+	tests := []struct {
+		guard     *stepGuard
+		succeeded bool
+		want      string
+	}{
+		{&stepGuard{kind: guardWhen, command: "test -f /etc/app"}, true, ""},
+		{&stepGuard{kind: guardWhen, command: "test -f /etc/app"}, false, "when `test -f /etc/app` failed"},
+		{&stepGuard{kind: guardUnless, command: "pgrep app"}, true, "unless `pgrep app` succeeded"},
+		{&stepGuard{kind: guardUnless, command: "pgrep app"}, false, ""},
+		{&stepGuard{kind: guardCreates, command: "test -e '/opt/my app'"}, true, "'/opt/my app' exists"},
+		{&stepGuard{kind: guardCreates, command: "test -e '/opt/my app'"}, false, ""},
+	}
+
+	for _, test := range tests {
+		if got := test.guard.skipReason(test.succeeded); got != test.want {
+			t.Errorf("%s `%s` succeeding %t: skip reason %q, expected %q",
+				test.guard.kind, test.guard.command, test.succeeded, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/fatih/color"
+)
+
+// LocalResult is the outcome of a local_before or local_after command run on this machine.
+type LocalResult struct {
+	Command  string
+	Index    int
+	Duration time.Duration
+
+	// ExitStatus is the exit status, or -1 when the command never reported one.
+	ExitStatus int
+	// Output holds what the command printed on stdout and stderr.
+	Output string
+	// Err is set when the command failed.
+	Err error
+	// Ignored is set when the command failed but its on_failure policy is ignore.
+	Ignored bool
+}
+
+// Succeeded reports whether the command succeeded.
+func (r *LocalResult) Succeeded() bool {
+	return r.Err == nil
+}
+
+// planLocal evaluates the local commands of the recipe, which only see the args and the
+// run variables since they don't run on any host. local_before can only use what earlier
+// stages promoted, given as before, and local_after what the plan promoted as well.
+func (p *Plan) planLocal(before *recipe.Registers) error {
+	if err := checkCaptured(localCommands(p.recipe.LocalBefore), before); err != nil {
+		return fmt.Errorf("local_before: %s", err.Error())
+	}
+	if err := checkCaptured(localCommands(p.recipe.LocalAfter), p.registers.Run()); err != nil {
+		return fmt.Errorf("local_after: %s", err.Error())
+	}
+
+	var err error
+	if p.LocalBefore, err = p.localCommands(p.recipe.LocalBefore); err != nil {
+		return fmt.Errorf("local_before: %s", err.Error())
+	}
+	if p.LocalAfter, err = p.localCommands(p.recipe.LocalAfter); err != nil {
+		return fmt.Errorf("local_after: %s", err.Error())
+	}
+	return nil
+}
+
+func localCommands(exec []*recipe.BladeRecipeCommand) []string {
+	commands := make([]string, len(exec))
+	for i, c := range exec {
+		commands[i] = c.Run
+	}
+	return commands
+}
+
+func (p *Plan) localCommands(exec []*recipe.BladeRecipeCommand) ([]*PlannedCommand, error) {
+	vars := p.runVars()
+	p.capturedVars(vars, nil, exec)
+	return prepareCommands(p.recipe.Args, exec, vars)
+}
+
+// runLocal runs the commands of a local section one after the other on this machine. A failing
+// command stops the section unless its on_failure policy says otherwise, and false is returned
+// when any command failed that isn't ignored.
+func (s *Session) runLocal(ctx context.Context, section string, commands []*PlannedCommand) ([]*LocalResult, bool) {
+	log.Printf("%s: %d commands", section, len(commands))
+
+	var results []*LocalResult
+	succeeded := true
+	for i, c := range commands {
+		if ctx.Err() != nil || s.isStopping() {
+			succeeded = false
+			break
+		}
+
+		result := runLocalCommand(ctx, c.Command, i+1)
+		results = append(results, result)
+		if result.Succeeded() {
+			continue
+		}
+
+		log.Printf("%s: %s command %d failed: %s", color.RedString("ERROR"), section, i+1, result.Err.Error())
+		if c.OnFailure == recipe.OnFailureIgnore {
+			result.Ignored = true
+			continue
+		}
+		succeeded = false
+		if c.OnFailure != recipe.OnFailureContinue {
+			break
+		}
+	}
+	return results, succeeded
+}
+
+// runLocalBefore runs the local_before commands of the plan into the result and returns false
+// when they failed, in which case no host may be run.
+func (s *Session) runLocalBefore(ctx context.Context, plan *Plan, result *SessionResult) bool {
+	if len(plan.LocalBefore) == 0 {
+		return true
+	}
+	var ok bool
+	if result.LocalBefore, ok = s.runLocal(ctx, "local_before", plan.LocalBefore); !ok {
+		log.Print(color.RedString("Aborting: local_before failed"))
+		result.Aborted = true
+	}
+	return ok
+}
+
+// runLocalAfter runs the local_after commands of the plan into the result once the hosts are
+// done. The run deadline doesn't apply to them but an interrupt skips them.
+func (s *Session) runLocalAfter(ctx context.Context, plan *Plan, result *SessionResult) {
+	if len(plan.LocalAfter) == 0 {
+		return
+	}
+	if s.isStopping() {
+		log.Print("local_after skipped since the run was interrupted")
+		return
+	}
+	// Evaluated again now that the steps promoted what they registered.
+	commands, err := plan.localCommands(plan.recipe.LocalAfter)
+	if err != nil {
+		log.Printf("%s: local_after: %s", color.RedString("ERROR"), err.Error())
+		result.LocalAfter = []*LocalResult{{Index: 1, ExitStatus: -1, Err: err}}
+		return
+	}
+	result.LocalAfter, _ = s.runLocal(ctx, "local_after", commands)
+}
+
+// localShell is the user's $SHELL, or /bin/sh when it isn't set.
+func localShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+func runLocalCommand(ctx context.Context, command string, index int) *LocalResult {
+	result := &LocalResult{Command: command, Index: index, ExitStatus: -1}
+	started := time.Now()
+
+	var mu sync.Mutex
+	var output bytes.Buffer
+	stdout := &localWriter{mu: &mu, output: &output, prefix: color.MagentaString("local:")}
+	stderr := &localWriter{mu: &mu, output: &output, prefix: color.RedString("local:")}
+
+	cmd := exec.CommandContext(ctx, localShell(), "-c", command)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	result.Err = cmd.Run()
+	stdout.flush()
+	stderr.flush()
+
+	if cmd.ProcessState != nil {
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitStatus = status.ExitStatus()
+		}
+	}
+	result.Output = output.String()
+	result.Duration = time.Since(started)
+	return result
+}
+
+// localWriter collects the output of a local command while logging it line by line.
+type localWriter struct {
+	mu     *sync.Mutex
+	output *bytes.Buffer
+	prefix string
+	line   []byte
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.output.Write(p)
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		sessionLogger.Println(w.prefix + " " + string(w.line[:i]))
+		w.line = w.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs what is left of a last line without a newline.
+func (w *localWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.line) > 0 {
+		sessionLogger.Println(w.prefix + " " + string(w.line))
+		w.line = nil
+	}
+}
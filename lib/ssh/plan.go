@@ -0,0 +1,671 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/gobwas/glob"
+	"github.com/hashicorp/hil/ast"
+)
+
+// Source describes where a resolved setting of a Plan came from.
+type Source string
+
+// Sources of resolved settings, roughly from the most to the least specific.
+const (
+	SourceInline     Source = "inline"
+	SourceFlag       Source = "flag"
+	SourceRecipe     Source = "recipe"
+	SourceHostLookup Source = "hostlookup"
+	SourceSSHConfig  Source = "ssh config"
+	SourceLocalUser  Source = "local user"
+	SourceDefault    Source = "default"
+)
+
+const defaultPort = 22
+
+// ErrNotApproved is returned by Start when the plan of the session wasn't approved.
+var ErrNotApproved = errors.New("The run was not approved")
+
+// Plan is everything a Session resolves before it dials a single host.
+type Plan struct {
+	Recipe string
+
+	Hosts       []*PlannedHost
+	HostsSource Source
+
+	Port        int
+	PortSource  Source
+	Concurrency int
+
+	ConcurrencySource Source
+
+	Steps []*PlannedStep
+	Retry *RetryPolicy
+
+	// LocalBefore and LocalAfter run on this machine before and after the hosts.
+	LocalBefore []*PlannedCommand
+	LocalAfter  []*PlannedCommand
+
+	// Stages holds the plan of every stage of a composed recipe, which has nothing else.
+	Stages []*Plan
+
+	Interaction *recipe.BladeRecipeInteraction
+
+	timeouts  *timeoutPolicy
+	canary    *canaryPolicy
+	batches   *batchPolicy
+	remaining []*PlannedHost
+	recipe    *recipe.BladeRecipeYaml
+	run       *runInfo
+	// captures is set once the plan runs.
+	captures *captures
+	// registers holds what the steps of the plan, and of the stages before it, registered.
+	registers *recipe.Registers
+}
+
+// PlannedHost is a host along with the user it will be dialed as.
+type PlannedHost struct {
+	// Host is the address to dial as host:port.
+	Host       string
+	User       string
+	UserSource Source
+}
+
+// key tells hosts apart that share an address but are dialed as different users.
+func (h *PlannedHost) key() string {
+	return h.User + "@" + h.Host
+}
+
+// PlannedStep is a step of commands that runs on all of its hosts before the next step starts.
+type PlannedStep struct {
+	// Name is empty for the implicit step of a recipe's exec list.
+	Name string
+	// Commands are shown as evaluated for the first host of the step.
+	Commands []*PlannedCommand
+	exec     []*recipe.BladeRecipeCommand
+
+	// Hosts replace the hosts of the session for this step when set, which then runs once per run.
+	Hosts []*PlannedHost
+	// HostFilter restricts the step to the hosts of the session that match it.
+	HostFilter string
+	hostFilter glob.Glob
+
+	Concurrency       int
+	ConcurrencySource Source
+	SleepAfter        time.Duration
+	ConfirmBefore     bool
+
+	// When, Unless and Creates guard the step on every host before its commands run.
+	When    string
+	Unless  string
+	Creates string
+	guards  []*stepGuard
+
+	// Register is the name the stdout of the step is kept under on every host.
+	Register       string
+	RegisterFormat string
+	Promote        bool
+
+	// Env, Cwd and Shell are the recipe's with those of the step applied.
+	Env   map[string]string
+	Cwd   string
+	Shell string
+}
+
+// PlannedCommand is a command ready to run remotely along with its failure policy.
+type PlannedCommand struct {
+	Command   string
+	OnFailure string
+
+	// byHost holds the command as evaluated for every host when it isn't the same for all.
+	byHost map[string]string
+}
+
+// VariesByHost reports whether the command differs from host to host.
+func (c *PlannedCommand) VariesByHost() bool {
+	return len(c.byHost) > 0
+}
+
+// Plan resolves the hosts, users, commands and policies of the session without dialing
+// anything. Start runs exactly what Plan returns.
+func (s *Session) Plan() (*Plan, error) {
+	rec, modifier := s.recipe, s.modifier
+	flags := modifier.FlagOverrides
+
+	if len(rec.Stages) > 0 {
+		return s.planStages()
+	}
+
+	// TODO: Potentially, the user can use hosts that have different user credentials.
+	// For now let's just assume that all hosts would belong to the same user credential scenario.
+
+	if s.run == nil {
+		s.run = newRunInfo()
+	}
+	plan := &Plan{Recipe: rec.Name, Interaction: rec.Interaction, recipe: rec, run: s.run}
+
+	var err error
+	if plan.Retry, err = resolveRetryPolicy(rec.Resilience, modifier); err != nil {
+		return nil, err
+	}
+	if plan.timeouts, err = resolveTimeoutPolicy(rec.Timeouts, modifier); err != nil {
+		return nil, err
+	}
+
+	plan.Concurrency, plan.ConcurrencySource = flags.Concurrency, SourceFlag
+	if plan.Concurrency == 0 {
+		plan.Concurrency, plan.ConcurrencySource = rec.Overrides.Concurrency, SourceRecipe
+	}
+	// Concurrency must be at least 1 to make progress.
+	if plan.Concurrency == 0 {
+		plan.Concurrency, plan.ConcurrencySource = 1, SourceDefault
+	}
+
+	plan.Port, plan.PortSource = flags.Port, SourceFlag
+	if plan.Port == 0 {
+		plan.Port, plan.PortSource = rec.Overrides.Port, SourceRecipe
+	}
+	if plan.Port == 0 {
+		plan.Port, plan.PortSource = defaultPort, SourceDefault
+	}
+
+	if err := s.resolveHosts(plan); err != nil {
+		return nil, err
+	}
+
+	if plan.canary, plan.remaining, err = resolveCanaryPolicy(rec, modifier, plan.Hosts, plan.Port); err != nil {
+		return nil, err
+	}
+	if plan.batches, err = resolveBatchPolicy(rec, modifier, len(plan.Hosts)); err != nil {
+		return nil, err
+	}
+
+	// Steps come last since commands are evaluated for the batch each host runs in.
+	if plan.Steps, err = s.planSteps(plan); err != nil {
+		return nil, err
+	}
+	if err := plan.planLocal(s.runRegisters()); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// resolveHosts determines the hosts of the plan and the user of each one of them.
+func (s *Session) resolveHosts(plan *Plan) error {
+	rec, flags := s.recipe, s.modifier.FlagOverrides
+
+	// Flags take precedence.
+	allHosts, source := flags.Hosts, SourceFlag
+	if len(allHosts) == 0 {
+		// Then Hosts declared on the recipe.
+		allHosts, source = rec.Hosts, SourceRecipe
+	}
+
+	// Finally HostLookup takes last precedence.
+	if len(allHosts) == 0 && rec.HostLookup != "" {
+		// Otherwise do dynamic host lookup here.
+		commandSlice := strings.Split(rec.HostLookup, " ")
+		out, err := exec.Command(commandSlice[0], commandSlice[1:]...).Output()
+		if err != nil {
+			return fmt.Errorf("Couldn't execute hostlookup command: %s", err.Error())
+		}
+
+		allHosts, source = strings.Split(string(out), ","), SourceHostLookup
+	}
+
+	for _, h := range allHosts {
+		planned, err := s.planHost(h, plan.Port)
+		if err != nil {
+			// Ignore what can't be parsed as host:port.
+			log.Printf("Couldn't parse: %s", h)
+			continue
+		}
+		plan.Hosts = append(plan.Hosts, planned)
+	}
+
+	if len(plan.Hosts) == 0 {
+		return errors.New("No host or hostlookup defined for this recipe, alternatively use the --hosts flag")
+	}
+	plan.HostsSource = source
+	return nil
+}
+
+// HostCount returns the number of hosts the plan runs on, the hosts of every stage included.
+func (p *Plan) HostCount() int {
+	count := len(p.Hosts)
+	for _, stage := range p.Stages {
+		count += stage.HostCount()
+	}
+	return count
+}
+
+// approve asks the modifier's Approve hook whether the plan may run.
+func (s *Session) approve(plan *Plan) error {
+	if s.modifier.Approve != nil && !s.modifier.Approve(plan) {
+		return ErrNotApproved
+	}
+	return nil
+}
+
+// planHost resolves a host as host:port along with the user it is dialed as.
+func (s *Session) planHost(h string, port int) (*PlannedHost, error) {
+	rec, flags := s.recipe, s.modifier.FlagOverrides
+
+	// inline user overrides any other user.
+	var inlineUser string
+	if userHost := strings.Split(strings.TrimSpace(h), "@"); len(userHost) == 2 {
+		inlineUser, h = userHost[0], userHost[1]
+	}
+
+	host, err := normalizeHost(h, port)
+	if err != nil {
+		return nil, err
+	}
+
+	planned := &PlannedHost{Host: host}
+	switch {
+	case inlineUser != "":
+		planned.User, planned.UserSource = inlineUser, SourceInline
+	case flags.User != "":
+		planned.User, planned.UserSource = flags.User, SourceFlag
+	case rec.Overrides.User != "":
+		planned.User, planned.UserSource = rec.Overrides.User, SourceRecipe
+	default:
+		planned.User, planned.UserSource = lookupUsernameForHost(host)
+	}
+	return planned, nil
+}
+
+// planSteps resolves the steps of the recipe, where an exec list is a single implicit step.
+func (s *Session) planSteps(plan *Plan) ([]*PlannedStep, error) {
+	rec, flags := s.recipe, s.modifier.FlagOverrides
+	plan.registers = s.runRegisters()
+
+	if len(rec.Steps) == 0 {
+		step := &PlannedStep{
+			exec:              rec.Exec,
+			Concurrency:       plan.Concurrency,
+			ConcurrencySource: plan.ConcurrencySource,
+			Env:               rec.Env,
+			Cwd:               rec.Cwd,
+			Shell:             rec.Shell,
+		}
+		if err := checkCaptured(step.commands(), plan.registers); err != nil {
+			return nil, err
+		}
+		var err error
+		if step.Commands, err = plan.planCommands(step); err != nil {
+			return nil, err
+		}
+		return []*PlannedStep{step}, nil
+	}
+	if len(rec.Exec) > 0 {
+		return nil, errors.New("A recipe can't have both exec and steps, move the exec commands into a step")
+	}
+
+	var steps []*PlannedStep
+	for i, recStep := range rec.Steps {
+		name := recStep.Name
+		if name == "" {
+			name = fmt.Sprintf("step %d", i+1)
+		}
+
+		step := &PlannedStep{
+			Name:              name,
+			exec:              recStep.Exec,
+			HostFilter:        recStep.HostFilter,
+			Concurrency:       plan.Concurrency,
+			ConcurrencySource: plan.ConcurrencySource,
+			ConfirmBefore:     recStep.ConfirmBefore,
+			When:              recStep.When,
+			Unless:            recStep.Unless,
+			Creates:           recStep.Creates,
+			guards:            planGuards(recStep),
+			Register:          recStep.Register,
+			RegisterFormat:    recStep.RegisterFormat,
+			Promote:           recStep.Promote,
+			Env:               mergeEnv(rec.Env, recStep.Env),
+			Cwd:               rec.Cwd,
+			Shell:             rec.Shell,
+		}
+		if recStep.Cwd != "" {
+			step.Cwd = recStep.Cwd
+		}
+		if recStep.Shell != "" {
+			step.Shell = recStep.Shell
+		}
+
+		// A concurrency flag still beats the step.
+		if recStep.Concurrency > 0 && flags.Concurrency == 0 {
+			step.Concurrency, step.ConcurrencySource = recStep.Concurrency, SourceRecipe
+		}
+
+		var err error
+		for _, h := range recStep.Hosts {
+			host, err := s.planHost(h, plan.Port)
+			if err != nil {
+				return nil, fmt.Errorf("Step %s: couldn't parse host: %s", name, h)
+			}
+			step.Hosts = append(step.Hosts, host)
+		}
+
+		if recStep.HostFilter != "" {
+			if step.hostFilter, err = glob.Compile(recStep.HostFilter); err != nil {
+				return nil, fmt.Errorf("Step %s: invalid hostfilter %q: %s", name, recStep.HostFilter, err.Error())
+			}
+		}
+
+		switch {
+		case step.Register == "" && (step.RegisterFormat != "" || step.Promote):
+			return nil, fmt.Errorf("Step %s: registerformat and promote need a register name", name)
+		case !validRegisterName.MatchString(step.Register) && step.Register != "":
+			return nil, fmt.Errorf("Step %s: invalid register name %q, use letters, digits, _ and -", name, step.Register)
+		}
+		switch step.RegisterFormat {
+		case "":
+			step.RegisterFormat = RegisterTrim
+		case RegisterTrim, RegisterJSON:
+		default:
+			return nil, fmt.Errorf("Step %s: unknown registerformat %q, expected %s or %s", name, step.RegisterFormat, RegisterTrim, RegisterJSON)
+		}
+
+		if recStep.SleepAfter != "" {
+			if step.SleepAfter, err = time.ParseDuration(recStep.SleepAfter); err != nil || step.SleepAfter < 0 {
+				return nil, fmt.Errorf("Step %s: invalid sleepafter %q, expected a duration like 30s", name, recStep.SleepAfter)
+			}
+		}
+
+		if err := checkCaptured(step.commands(), plan.registers); err != nil {
+			return nil, fmt.Errorf("Step %s: %s", name, err.Error())
+		}
+		if step.Register != "" {
+			plan.registers.Add(step.Register, step.Promote)
+		}
+		if step.Commands, err = plan.planCommands(step); err != nil {
+			return nil, fmt.Errorf("Step %s: %s", name, err.Error())
+		}
+		if _, err = plan.guardsFor(step, &PlannedHost{}, 1); err != nil {
+			return nil, fmt.Errorf("Step %s: %s", name, err.Error())
+		}
+
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// planCommands evaluates the commands of the step for every host it targets, so that a
+// command that doesn't evaluate is caught before anything runs.
+func (p *Plan) planCommands(step *PlannedStep) ([]*PlannedCommand, error) {
+	targets := step.targets(p.Hosts)
+	if len(targets) == 0 {
+		// The step won't run but its commands still have to be valid.
+		targets = []*PlannedHost{{}}
+	}
+
+	byHost := make([][]*PlannedCommand, len(targets))
+	for i, host := range targets {
+		commands, err := p.commandsFor(step, host, p.batchOf(host))
+		if err != nil {
+			return nil, err
+		}
+		byHost[i] = commands
+	}
+
+	commands := byHost[0]
+	for i, c := range commands {
+		for j := range targets {
+			if byHost[j][i].Command != c.Command {
+				c.byHost = make(map[string]string)
+				break
+			}
+		}
+		if c.byHost == nil {
+			continue
+		}
+		for j, host := range targets {
+			c.byHost[host.key()] = byHost[j][i].Command
+		}
+	}
+	return commands, nil
+}
+
+// mergeEnv returns the env of a recipe with the env of a step merged over it.
+func mergeEnv(recipeEnv, stepEnv map[string]string) map[string]string {
+	if len(stepEnv) == 0 {
+		return recipeEnv
+	}
+	env := make(map[string]string, len(recipeEnv)+len(stepEnv))
+	for name, value := range recipeEnv {
+		env[name] = value
+	}
+	for name, value := range stepEnv {
+		env[name] = value
+	}
+	return env
+}
+
+// targets returns the hosts the step runs on out of the hosts of a batch.
+func (step *PlannedStep) targets(hosts []*PlannedHost) []*PlannedHost {
+	if len(step.Hosts) > 0 {
+		return step.Hosts
+	}
+	if step.hostFilter == nil {
+		return hosts
+	}
+
+	var matched []*PlannedHost
+	for _, h := range hosts {
+		name, _, err := net.SplitHostPort(h.Host)
+		if err != nil {
+			name = h.Host
+		}
+		if step.hostFilter.Match(name) || step.hostFilter.Match(h.Host) {
+			matched = append(matched, h)
+		}
+	}
+	return matched
+}
+
+// prepareCommands validates the recipe's exec entries and applies the recipe arguments along
+// with the variables scoped to a host.
+func prepareCommands(args recipe.BladeRecipeArguments, exec []*recipe.BladeRecipeCommand, scoped map[string]ast.Variable) ([]*PlannedCommand, error) {
+	var raw []string
+	for i, c := range exec {
+		if c == nil || strings.TrimSpace(c.Run) == "" {
+			return nil, fmt.Errorf("Command %d is empty", i+1)
+		}
+		switch c.OnFailure {
+		case "", recipe.OnFailureAbort, recipe.OnFailureContinue, recipe.OnFailureIgnore:
+		default:
+			return nil, fmt.Errorf("Command %d has an unknown on_failure policy %q, expected one of: %s, %s, %s",
+				i+1, c.OnFailure, recipe.OnFailureAbort, recipe.OnFailureContinue, recipe.OnFailureIgnore)
+		}
+		raw = append(raw, c.Run)
+	}
+
+	// Apply recipe will apply the recipe arguments to the commands
+	// assumming they're defined.
+	applied, err := applyRecipeArgs(args, raw, scoped)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply recipe arguments to commands with err: %s", err.Error())
+	}
+
+	commands := make([]*PlannedCommand, len(applied))
+	for i, c := range applied {
+		commands[i] = &PlannedCommand{Command: c, OnFailure: exec[i].OnFailure}
+	}
+	return commands, nil
+}
+
+// Describe writes a human readable account of the plan along with the source of every setting.
+func (p *Plan) Describe(w io.Writer) {
+	fmt.Fprintf(w, "Recipe: %s\n", p.Recipe)
+	if i := p.Interaction; i != nil && i.Prompts() {
+		if i.ConfirmText != "" {
+			fmt.Fprintf(w, "Confirmation: type %q\n", i.ConfirmText)
+		} else {
+			fmt.Fprintf(w, "Confirmation: yes or no\n")
+		}
+	}
+	if len(p.Stages) > 0 {
+		fmt.Fprintf(w, "Timeouts: %s\n", p.timeouts)
+		describeLocal(w, "Local before", p.LocalBefore)
+		for i, stage := range p.Stages {
+			fmt.Fprintf(w, "Stage %d/%d:\n", i+1, len(p.Stages))
+			var buf bytes.Buffer
+			stage.Describe(&buf)
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				fmt.Fprintf(w, "  %s", line)
+			}
+			fmt.Fprintln(w)
+		}
+		describeLocal(w, "Local after", p.LocalAfter)
+		return
+	}
+
+	fmt.Fprintf(w, "Port: %d (%s)\n", p.Port, p.PortSource)
+	fmt.Fprintf(w, "Concurrency: %d (%s)\n", p.Concurrency, p.ConcurrencySource)
+	fmt.Fprintf(w, "Retries: %s (dial retries: %s, command retries: %s, backoff: %s)\n",
+		p.Retry, p.Retry.DialRetriesSource, p.Retry.CommandRetriesSource, p.Retry.BackoffSource)
+	fmt.Fprintf(w, "Timeouts: %s\n", p.timeouts)
+
+	if len(p.canary.hosts) > 0 {
+		fmt.Fprintf(w, "Canary: %d hosts, confirm before release: %t\n", len(p.canary.hosts), p.canary.confirm)
+	}
+	fmt.Fprintf(w, "Batches: %s\n", p.batches.describe(len(p.remaining)))
+
+	fmt.Fprintf(w, "Hosts (%s): %d\n", p.HostsSource, len(p.Hosts))
+	for _, h := range p.Hosts {
+		fmt.Fprintf(w, "  %s as %s (%s)\n", h.Host, h.User, h.UserSource)
+	}
+	describeLocal(w, "Local before", p.LocalBefore)
+
+	for i, step := range p.Steps {
+		if step.Name != "" {
+			fmt.Fprintf(w, "Step %d/%d: %s\n", i+1, len(p.Steps), step.Name)
+			switch {
+			case len(step.Hosts) > 0:
+				fmt.Fprintf(w, "  Hosts (recipe): %d\n", len(step.Hosts))
+				for _, h := range step.Hosts {
+					fmt.Fprintf(w, "    %s as %s (%s)\n", h.Host, h.User, h.UserSource)
+				}
+			case step.HostFilter != "":
+				fmt.Fprintf(w, "  Host filter: %s (%d hosts)\n", step.HostFilter, len(step.targets(p.Hosts)))
+			}
+			fmt.Fprintf(w, "  Concurrency: %d (%s)\n", step.Concurrency, step.ConcurrencySource)
+			if step.ConfirmBefore {
+				fmt.Fprintf(w, "  Confirm before: true\n")
+			}
+			if step.SleepAfter > 0 {
+				fmt.Fprintf(w, "  Sleep after: %s\n", step.SleepAfter)
+			}
+			if step.Creates != "" {
+				fmt.Fprintf(w, "  Creates: %s\n", step.Creates)
+			}
+			if step.When != "" {
+				fmt.Fprintf(w, "  When: %s\n", step.When)
+			}
+			if step.Unless != "" {
+				fmt.Fprintf(w, "  Unless: %s\n", step.Unless)
+			}
+			if step.Register != "" {
+				fmt.Fprintf(w, "  Register: %s (%s, promote: %t)\n", step.Register, step.RegisterFormat, step.Promote)
+			}
+		}
+		describeEnv(w, step, step.Name != "")
+		describeCommands(w, step.Commands, step.targets(p.Hosts), step.Name != "")
+	}
+	describeLocal(w, "Local after", p.LocalAfter)
+}
+
+func describeLocal(w io.Writer, title string, commands []*PlannedCommand) {
+	if len(commands) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s: %d\n", title, len(commands))
+	for i, c := range commands {
+		onFailure := c.OnFailure
+		if onFailure == "" {
+			onFailure = recipe.OnFailureAbort
+		}
+		fmt.Fprintf(w, "  %d. %s (on_failure: %s)\n", i+1, c.Command, onFailure)
+	}
+}
+
+func describeEnv(w io.Writer, step *PlannedStep, nested bool) {
+	indent := ""
+	if nested {
+		indent = "  "
+	}
+	if len(step.Env) > 0 {
+		var names []string
+		for name := range step.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "%sEnv:\n", indent)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s  %s=%s\n", indent, name, step.Env[name])
+		}
+	}
+	if step.Cwd != "" {
+		fmt.Fprintf(w, "%sCwd: %s\n", indent, step.Cwd)
+	}
+	if step.Shell != "" {
+		fmt.Fprintf(w, "%sShell: %s\n", indent, step.Shell)
+	}
+}
+
+func describeCommands(w io.Writer, commands []*PlannedCommand, hosts []*PlannedHost, nested bool) {
+	indent := ""
+	if nested {
+		indent = "  "
+	}
+	fmt.Fprintf(w, "%sCommands: %d\n", indent, len(commands))
+	for i, c := range commands {
+		onFailure := c.OnFailure
+		if onFailure == "" {
+			onFailure = recipe.OnFailureAbort
+		}
+		if !c.VariesByHost() {
+			fmt.Fprintf(w, "%s  %d. %s (on_failure: %s)\n", indent, i+1, c.Command, onFailure)
+			continue
+		}
+		fmt.Fprintf(w, "%s  %d. varies by host (on_failure: %s)\n", indent, i+1, onFailure)
+		for _, h := range hosts {
+			fmt.Fprintf(w, "%s     %s: %s\n", indent, h.Host, c.byHost[h.key()])
+		}
+	}
+}
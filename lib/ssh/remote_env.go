@@ -0,0 +1,118 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteEnv is the environment, folder and shell the commands of a step run with on a host.
+type remoteEnv struct {
+	env   map[string]string
+	cwd   string
+	shell string
+	run   *runInfo
+}
+
+func (step *PlannedStep) remoteEnv(run *runInfo) *remoteEnv {
+	return &remoteEnv{env: step.Env, cwd: step.Cwd, shell: step.Shell, run: run}
+}
+
+// prepare sets the env on the remote session where the host allows it and returns the
+// command to start on it.
+func (e *remoteEnv) prepare(session *ssh.Session, hostname, command string) string {
+	names := make([]string, 0, len(e.env))
+	for name := range e.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Once a host rejected Setenv, usually because of its AcceptEnv, the env is exported within
+	// the commands themselves on that host for the rest of the run.
+	exportEnv := e.run.rejectsEnv(hostname)
+	if !exportEnv {
+		for _, name := range names {
+			if err := session.Setenv(name, e.env[name]); err != nil {
+				if e.run.rejectEnv(hostname) {
+					log.Printf("%s: %s doesn't accept %s, exporting the env within the commands instead",
+						color.YellowString("WARN"), hostname, name)
+				}
+				exportEnv = true
+				break
+			}
+		}
+	}
+
+	var prefix []string
+	if exportEnv {
+		for _, name := range names {
+			prefix = append(prefix, "export "+name+"="+recipe.ShellQuote(e.env[name])+";")
+		}
+	}
+	if e.cwd != "" {
+		prefix = append(prefix, "cd "+quoteFolder(e.cwd)+" &&")
+	}
+	if len(prefix) > 0 {
+		command = strings.Join(prefix, " ") + " " + command
+	}
+
+	if e.shell != "" {
+		command = e.shell + " " + recipe.ShellQuote(command)
+	}
+	return command
+}
+
+// rejectsEnv reports whether the host rejected Setenv earlier in the run.
+func (r *runInfo) rejectsEnv(hostname string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.envRejected[hostname]
+}
+
+// rejectEnv records that the host rejected Setenv and returns true the first time it does so.
+func (r *runInfo) rejectEnv(hostname string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.envRejected[hostname] {
+		return false
+	}
+	if r.envRejected == nil {
+		r.envRejected = make(map[string]bool)
+	}
+	r.envRejected[hostname] = true
+	return true
+}
+
+// quoteFolder quotes a folder for the shell while keeping a leading ~/ working.
+func quoteFolder(folder string) string {
+	if strings.HasPrefix(folder, "~/") {
+		return "~/" + recipe.ShellQuote(folder[2:])
+	}
+	return recipe.ShellQuote(folder)
+}
@@ -0,0 +1,254 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/deckarep/blade/lib/recipe"
+)
+
+// Supported values of the recipe resilience RetryBackoffStrategy.
+const (
+	BackoffConstant    = "constant"
+	BackoffLinear      = "linear"
+	BackoffExponential = "exponential"
+)
+
+const (
+	defaultDialRetries    = 3
+	defaultCommandRetries = 2
+)
+
+// RetryPolicy describes how failed dials and failed commands are retried.
+type RetryPolicy struct {
+	DialRetries    int
+	CommandRetries int
+
+	Strategy string
+	// Interval is the wait before the first retry.
+	Interval time.Duration
+	// Step is added to the wait on every linear retry.
+	Step time.Duration
+	// Multiplier grows the wait on every exponential retry.
+	Multiplier float64
+	// MaxElapsedTime stops retrying once exceeded, zero means no limit.
+	MaxElapsedTime time.Duration
+
+	// Sources record where the retry limits and the backoff were resolved from.
+	DialRetriesSource    Source
+	CommandRetriesSource Source
+	BackoffSource        Source
+}
+
+// resolveRetryPolicy applies the precedence: flag, then recipe, then default.
+func resolveRetryPolicy(resilience *recipe.BladeRecipeResilience, modifier *SessionModifier) (*RetryPolicy, error) {
+	if resilience == nil {
+		resilience = &recipe.BladeRecipeResilience{}
+	}
+
+	policy := &RetryPolicy{
+		DialRetries:    defaultDialRetries,
+		CommandRetries: defaultCommandRetries,
+		Strategy:       BackoffExponential,
+		Interval:       backoff.DefaultInitialInterval,
+		Multiplier:     backoff.DefaultMultiplier,
+		MaxElapsedTime: backoff.DefaultMaxElapsedTime,
+
+		DialRetriesSource:    SourceDefault,
+		CommandRetriesSource: SourceDefault,
+		BackoffSource:        SourceDefault,
+	}
+
+	// Recipe: the specific limits win over the general retries.
+	if resilience.Retries != nil {
+		policy.DialRetries, policy.DialRetriesSource = *resilience.Retries, SourceRecipe
+		policy.CommandRetries, policy.CommandRetriesSource = *resilience.Retries, SourceRecipe
+	}
+	if resilience.DialRetries != nil {
+		policy.DialRetries, policy.DialRetriesSource = *resilience.DialRetries, SourceRecipe
+	}
+	if resilience.CommandRetries != nil {
+		policy.CommandRetries, policy.CommandRetriesSource = *resilience.CommandRetries, SourceRecipe
+	}
+
+	// Flags: same rule as above but they always beat the recipe.
+	flags := modifier.FlagOverrides
+	if flags.Retries != nil {
+		policy.DialRetries, policy.DialRetriesSource = *flags.Retries, SourceFlag
+		policy.CommandRetries, policy.CommandRetriesSource = *flags.Retries, SourceFlag
+	}
+	if flags.DialRetries != nil {
+		policy.DialRetries, policy.DialRetriesSource = *flags.DialRetries, SourceFlag
+	}
+	if flags.CommandRetries != nil {
+		policy.CommandRetries, policy.CommandRetriesSource = *flags.CommandRetries, SourceFlag
+	}
+
+	if policy.DialRetries < 0 || policy.CommandRetries < 0 {
+		return nil, fmt.Errorf("Retries must not be a negative number")
+	}
+
+	if resilience.RetryBackoffStrategy != "" {
+		policy.Strategy = strings.ToLower(resilience.RetryBackoffStrategy)
+	}
+
+	if resilience.RetryBackoffStrategy != "" || resilience.WaitDuration != "" ||
+		resilience.RetryBackoffMultiplier != "" || resilience.MaxElapsedTime != "" {
+		policy.BackoffSource = SourceRecipe
+	}
+
+	var err error
+	if resilience.WaitDuration != "" {
+		if policy.Interval, err = time.ParseDuration(resilience.WaitDuration); err != nil {
+			return nil, fmt.Errorf("Invalid resilience waitduration %q: %s", resilience.WaitDuration, err.Error())
+		}
+	}
+	if resilience.MaxElapsedTime != "" {
+		if policy.MaxElapsedTime, err = time.ParseDuration(resilience.MaxElapsedTime); err != nil {
+			return nil, fmt.Errorf("Invalid resilience maxelapsedtime %q: %s", resilience.MaxElapsedTime, err.Error())
+		}
+	}
+
+	multiplier := resilience.RetryBackoffMultiplier
+	switch policy.Strategy {
+	case BackoffConstant:
+	case BackoffLinear:
+		policy.Step = policy.Interval
+		if multiplier != "" {
+			if policy.Step, err = time.ParseDuration(multiplier); err != nil {
+				return nil, fmt.Errorf("Linear retrybackoffmultiplier %q must be a duration like 5s", multiplier)
+			}
+		}
+	case BackoffExponential:
+		if multiplier != "" {
+			if policy.Multiplier, err = strconv.ParseFloat(multiplier, 64); err != nil || policy.Multiplier < 1 {
+				return nil, fmt.Errorf("Exponential retrybackoffmultiplier %q must be a number of at least 1", multiplier)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unknown retrybackoffstrategy %q, expected one of: %s, %s, %s",
+			resilience.RetryBackoffStrategy, BackoffConstant, BackoffLinear, BackoffExponential)
+	}
+
+	return policy, nil
+}
+
+// dialBackOff returns a fresh BackOff for redialing a host, BackOffs are not thread-safe.
+func (p *RetryPolicy) dialBackOff() backoff.BackOff {
+	return p.newBackOff(p.DialRetries)
+}
+
+// commandBackOff returns a fresh BackOff for rerunning a single command.
+func (p *RetryPolicy) commandBackOff() backoff.BackOff {
+	return p.newBackOff(p.CommandRetries)
+}
+
+func (p *RetryPolicy) newBackOff(retries int) backoff.BackOff {
+	// WithMaxTries treats zero as unlimited so no retries has to stop explicitly.
+	if retries == 0 {
+		return &backoff.StopBackOff{}
+	}
+
+	var b backoff.BackOff
+	switch p.Strategy {
+	case BackoffConstant:
+		b = backoff.NewConstantBackOff(p.Interval)
+	case BackoffLinear:
+		b = &linearBackOff{initial: p.Interval, step: p.Step}
+	default:
+		exp := backoff.NewExponentialBackOff()
+		exp.InitialInterval = p.Interval
+		exp.Multiplier = p.Multiplier
+		// Elapsed time is enforced below for every strategy alike.
+		exp.MaxElapsedTime = 0
+		exp.Reset()
+		b = exp
+	}
+
+	if p.MaxElapsedTime > 0 {
+		b = &maxElapsedBackOff{delegate: b, maxElapsed: p.MaxElapsedTime}
+	}
+	return backoff.WithMaxTries(b, uint64(retries))
+}
+
+// String describes the policy in a single line, handy for logs and plans.
+func (p *RetryPolicy) String() string {
+	var wait string
+	switch p.Strategy {
+	case BackoffConstant:
+		wait = fmt.Sprintf("every %s", p.Interval)
+	case BackoffLinear:
+		wait = fmt.Sprintf("from %s plus %s each retry", p.Interval, p.Step)
+	default:
+		wait = fmt.Sprintf("from %s times %g each retry", p.Interval, p.Multiplier)
+	}
+
+	elapsed := "no time limit"
+	if p.MaxElapsedTime > 0 {
+		elapsed = fmt.Sprintf("at most %s", p.MaxElapsedTime)
+	}
+
+	return fmt.Sprintf("%d dial retries, %d command retries, %s backoff %s, %s",
+		p.DialRetries, p.CommandRetries, p.Strategy, wait, elapsed)
+}
+
+// linearBackOff waits initial, then initial+step, initial+2*step and so on.
+type linearBackOff struct {
+	initial time.Duration
+	step    time.Duration
+	retries int
+}
+
+func (b *linearBackOff) Reset() {
+	b.retries = 0
+}
+
+func (b *linearBackOff) NextBackOff() time.Duration {
+	next := b.initial + time.Duration(b.retries)*b.step
+	b.retries++
+	return next
+}
+
+// maxElapsedBackOff stops its delegate once maxElapsed has passed since the last Reset.
+type maxElapsedBackOff struct {
+	delegate   backoff.BackOff
+	maxElapsed time.Duration
+	started    time.Time
+}
+
+func (b *maxElapsedBackOff) Reset() {
+	b.started = time.Now()
+	b.delegate.Reset()
+}
+
+func (b *maxElapsedBackOff) NextBackOff() time.Duration {
+	next := b.delegate.NextBackOff()
+	if next == backoff.Stop || time.Since(b.started)+next > b.maxElapsed {
+		return backoff.Stop
+	}
+	return next
+}
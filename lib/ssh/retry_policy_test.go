@@ -0,0 +1,225 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/deckarep/blade/lib/recipe"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestResolveRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		resilience     *recipe.BladeRecipeResilience
+		flags          func(m *SessionModifier)
+		dialRetries    int
+		commandRetries int
+		dialSource     Source
+		commandSource  Source
+		strategy       string
+		interval       time.Duration
+		step           time.Duration
+		multiplier     float64
+		wantErr        bool
+	}{
+		{
+			name:           "defaults",
+			dialRetries:    defaultDialRetries,
+			commandRetries: defaultCommandRetries,
+			dialSource:     SourceDefault,
+			commandSource:  SourceDefault,
+			strategy:       BackoffExponential,
+			interval:       backoff.DefaultInitialInterval,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name:           "recipe retries apply to both",
+			resilience:     &recipe.BladeRecipeResilience{Retries: intPtr(5)},
+			dialRetries:    5,
+			commandRetries: 5,
+			dialSource:     SourceRecipe,
+			commandSource:  SourceRecipe,
+			strategy:       BackoffExponential,
+			interval:       backoff.DefaultInitialInterval,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name:           "specific recipe retries beat the general ones",
+			resilience:     &recipe.BladeRecipeResilience{Retries: intPtr(5), CommandRetries: intPtr(0)},
+			dialRetries:    5,
+			commandRetries: 0,
+			dialSource:     SourceRecipe,
+			commandSource:  SourceRecipe,
+			strategy:       BackoffExponential,
+			interval:       backoff.DefaultInitialInterval,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name:       "flags beat the recipe",
+			resilience: &recipe.BladeRecipeResilience{Retries: intPtr(5), DialRetries: intPtr(7)},
+			flags: func(m *SessionModifier) {
+				m.FlagOverrides.Retries = intPtr(1)
+			},
+			dialRetries:    1,
+			commandRetries: 1,
+			dialSource:     SourceFlag,
+			commandSource:  SourceFlag,
+			strategy:       BackoffExponential,
+			interval:       backoff.DefaultInitialInterval,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name:       "specific flag beats the general flag",
+			resilience: &recipe.BladeRecipeResilience{CommandRetries: intPtr(4)},
+			flags: func(m *SessionModifier) {
+				m.FlagOverrides.Retries = intPtr(1)
+				m.FlagOverrides.DialRetries = intPtr(9)
+			},
+			dialRetries:    9,
+			commandRetries: 1,
+			dialSource:     SourceFlag,
+			commandSource:  SourceFlag,
+			strategy:       BackoffExponential,
+			interval:       backoff.DefaultInitialInterval,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name: "negative retries",
+			flags: func(m *SessionModifier) {
+				m.FlagOverrides.CommandRetries = intPtr(-1)
+			},
+			wantErr: true,
+		},
+		{
+			name:           "constant",
+			resilience:     &recipe.BladeRecipeResilience{RetryBackoffStrategy: "Constant", WaitDuration: "2s"},
+			dialRetries:    defaultDialRetries,
+			commandRetries: defaultCommandRetries,
+			dialSource:     SourceDefault,
+			commandSource:  SourceDefault,
+			strategy:       BackoffConstant,
+			interval:       2 * time.Second,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name:           "linear steps by the interval",
+			resilience:     &recipe.BladeRecipeResilience{RetryBackoffStrategy: "linear", WaitDuration: "1s"},
+			dialRetries:    defaultDialRetries,
+			commandRetries: defaultCommandRetries,
+			dialSource:     SourceDefault,
+			commandSource:  SourceDefault,
+			strategy:       BackoffLinear,
+			interval:       time.Second,
+			step:           time.Second,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name:           "linear with a step",
+			resilience:     &recipe.BladeRecipeResilience{RetryBackoffStrategy: "linear", WaitDuration: "1s", RetryBackoffMultiplier: "5s"},
+			dialRetries:    defaultDialRetries,
+			commandRetries: defaultCommandRetries,
+			dialSource:     SourceDefault,
+			commandSource:  SourceDefault,
+			strategy:       BackoffLinear,
+			interval:       time.Second,
+			step:           5 * time.Second,
+			multiplier:     backoff.DefaultMultiplier,
+		},
+		{
+			name:       "linear with a factor",
+			resilience: &recipe.BladeRecipeResilience{RetryBackoffStrategy: "linear", RetryBackoffMultiplier: "2"},
+			wantErr:    true,
+		},
+		{
+			name:           "exponential with a factor",
+			resilience:     &recipe.BladeRecipeResilience{RetryBackoffMultiplier: "3"},
+			dialRetries:    defaultDialRetries,
+			commandRetries: defaultCommandRetries,
+			dialSource:     SourceDefault,
+			commandSource:  SourceDefault,
+			strategy:       BackoffExponential,
+			interval:       backoff.DefaultInitialInterval,
+			multiplier:     3,
+		},
+		{
+			name:       "exponential shrinking",
+			resilience: &recipe.BladeRecipeResilience{RetryBackoffMultiplier: "0.5"},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown strategy",
+			resilience: &recipe.BladeRecipeResilience{RetryBackoffStrategy: "fibonacci"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid wait",
+			resilience: &recipe.BladeRecipeResilience{WaitDuration: "soon"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid max elapsed time",
+			resilience: &recipe.BladeRecipeResilience{MaxElapsedTime: "10"},
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		modifier := &SessionModifier{}
+		if test.flags != nil {
+			test.flags(modifier)
+		}
+
+		policy, err := resolveRetryPolicy(test.resilience, modifier)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", test.name, policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if policy.DialRetries != test.dialRetries || policy.DialRetriesSource != test.dialSource {
+			t.Errorf("%s: dial retries %d (%s), expected %d (%s)", test.name,
+				policy.DialRetries, policy.DialRetriesSource, test.dialRetries, test.dialSource)
+		}
+		if policy.CommandRetries != test.commandRetries || policy.CommandRetriesSource != test.commandSource {
+			t.Errorf("%s: command retries %d (%s), expected %d (%s)", test.name,
+				policy.CommandRetries, policy.CommandRetriesSource, test.commandRetries, test.commandSource)
+		}
+		if policy.Strategy != test.strategy || policy.Interval != test.interval ||
+			policy.Step != test.step || policy.Multiplier != test.multiplier {
+			t.Errorf("%s: backoff %s %s step %s x%g, expected %s %s step %s x%g", test.name,
+				policy.Strategy, policy.Interval, policy.Step, policy.Multiplier,
+				test.strategy, test.interval, test.step, test.multiplier)
+		}
+	}
+}
@@ -22,15 +22,13 @@ SOFTWARE.
 package ssh
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"regexp"
-	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -42,120 +40,502 @@ import (
 )
 
 var (
-	hostQueue             = make(chan string)
-	hostWg                sync.WaitGroup
-	successfullyCompleted int32
-	failedCompleted       int32
-	sessionLogger         = log.New(os.Stdout, "", 0)
+	// Whatever hosts print goes through here, secrets included, so they get redacted.
+	sessionLogger = log.New(recipe.RedactingWriter(os.Stdout), "", 0)
 )
 
-// StartSession kicks off a Blade Recipe as a session of work to be completed.
-func StartSession(recipe *recipe.BladeRecipeYaml, modifier *SessionModifier) {
+// Session is a single run of a Blade Recipe. A Session owns its host queue, wait group
+// and results so that any number of sessions may run in the same process without
+// affecting each other.
+type Session struct {
+	recipe      *recipe.BladeRecipeYaml
+	modifier    *SessionModifier
+	plan        *Plan
+	run         *runInfo
+	retryPolicy *RetryPolicy
+	timeouts    *timeoutPolicy
+	// failFast cancels every host of the run, nil unless fail-fast is enabled.
+	failFast context.CancelFunc
+
+	hostQueue    chan *hostJob
+	hostWg       sync.WaitGroup
+	consumerDone chan struct{}
+
+	// stopping is closed by Interrupt, after which no further hosts are started.
+	stopMu   sync.Mutex
+	stopping chan struct{}
+	remotes  map[*ssh.Session]struct{}
+	// stage is the session of the stage in flight when running a composed recipe.
+	stage *Session
+	// registers holds what the stages before this one promoted, nil unless it's a stage.
+	registers *recipe.Registers
+	// pinnedDone holds the steps with hosts of their own that already ran, as they run once
+	// per run rather than in every batch.
+	pinnedDone map[*PlannedStep]bool
+
+	resultsMu sync.Mutex
+	results   []*HostResult
+	byHost    map[string]*HostResult
+	skipped   []string
+}
 
-	// TODO: Long term we need to do 3 things.
-	// 1. Bind the the session modifier to the recipe.
-	// 2. Create a debug spot to dump the actual settings used before execution.
-	// 3. Make sure all of this happens in a single location so we don't have track down where modifiers are applied.
+// hostJob is a step to run on a single host as part of a batch.
+type hostJob struct {
+	host  *PlannedHost
+	step  *PlannedStep
+	batch int
+}
 
-	// TODO: Potentially, the user can use hosts that have different user credentials.
-	// For now let's just assume that all hosts would belong to the same user credential scenario.
+// NewSession creates a new Session for the recipe with the modifier applied.
+func NewSession(recipe *recipe.BladeRecipeYaml, modifier *SessionModifier) *Session {
+	if modifier == nil {
+		modifier = NewSessionModifier()
+	}
+	recipe.ApplyDefaults()
+	return &Session{
+		recipe:   recipe,
+		modifier: modifier,
+	}
+}
+
+// StartSession kicks off a Blade Recipe as a session of work to be completed.
+func StartSession(ctx context.Context, recipe *recipe.BladeRecipeYaml, modifier *SessionModifier) (*SessionResult, error) {
+	return NewSession(recipe, modifier).Start(ctx)
+}
 
-	// Apply recipe will apply the recipe arguments to the commands
-	// assumming they're defined.
-	// TODO: don't loop twice here, then later for each cmd processing.
-	sshCmds, err := applyRecipeArgs(recipe.Args, recipe.Exec)
+// Start runs the session to completion and blocks until every host is done.
+// An error is only returned when the session couldn't be started at all, such as
+// when no hosts could be resolved; failures on hosts are reported in the result.
+// Cancelling ctx stops any further hosts from starting and cancels those in flight.
+// A Session may be started again once a previous Start has returned, but it must
+// not be started concurrently with itself.
+func (s *Session) Start(ctx context.Context) (*SessionResult, error) {
+	s.reset()
+	// Every Start is a run of its own, which only the sessions of its stages share.
+	s.run = newRunInfo()
+	plan, err := s.Plan()
 	if err != nil {
-		log.Fatalf("%s: Failed to apply recipe arguments to commands with err: %s", color.RedString("ERROR"), err.Error())
+		return nil, err
 	}
+	if err := s.approve(plan); err != nil {
+		return nil, err
+	}
+	return s.startPlan(ctx, plan)
+}
+
+// reset readies the session for a run.
+func (s *Session) reset() {
+	s.hostQueue = make(chan *hostJob)
+	s.consumerDone = make(chan struct{})
+	s.results = nil
+	s.byHost = make(map[string]*HostResult)
+	s.skipped = nil
+	s.pinnedDone = make(map[*PlannedStep]bool)
+
+	s.stopMu.Lock()
+	s.stopping = make(chan struct{})
+	s.remotes = make(map[*ssh.Session]struct{})
+	s.stopMu.Unlock()
+}
+
+// startPlan runs a plan that was already approved, without planning it again.
+func (s *Session) startPlan(ctx context.Context, plan *Plan) (*SessionResult, error) {
+	recipe, modifier := s.recipe, s.modifier
+
+	if len(plan.Stages) > 0 {
+		return s.startStages(ctx, plan)
+	}
+
+	s.plan = plan
+	plan.captures = newCaptures()
+	s.retryPolicy = plan.Retry
+	s.timeouts = plan.timeouts
+	canaries, batches, remainingHosts := plan.canary, plan.batches, plan.remaining
 
-	actualConcurrency := modifier.FlagOverrides.Concurrency
-	if actualConcurrency == 0 {
-		actualConcurrency = recipe.Overrides.Concurrency
+	result := &SessionResult{
+		Recipe:  recipe.Name,
+		Started: time.Now(),
 	}
+	log.Print(color.GreenString(fmt.Sprintf("Recipe start: %s", recipe.Name)))
+
+	// The run deadline covers every host, including the ones still waiting in later batches,
+	// while local_after runs once it's over.
+	parent := ctx
+	ctx, cancel := withTimeout(ctx, s.timeouts.run)
+	defer cancel()
+
+	localBeforeFailed := !s.runLocalBefore(ctx, plan, result)
 
-	// Concurrency must be at least 1 to make progress.
-	if actualConcurrency == 0 {
-		actualConcurrency = 1
+	s.failFast = nil
+	if modifier.FlagOverrides.FailFast || recipe.Resilience.FailFast {
+		s.failFast = cancel
 	}
 
-	go consumeAndLimitConcurrency(recipe, sshCmds, actualConcurrency)
+	go s.consumeAndLimitConcurrency(ctx)
 
-	// Flags take precedence.
-	allHosts := modifier.FlagOverrides.Hosts
-	if len(allHosts) == 0 {
-		// Then Hosts declared on the recipe.
-		allHosts = recipe.Hosts
+	// Canaries run on their own and every one of them must succeed before the rest is released.
+	if len(canaries.hosts) > 0 && result.Aborted {
+		for _, h := range canaries.hosts {
+			s.recordSkipped(h)
+		}
+	} else if len(canaries.hosts) > 0 {
+		log.Printf("Canary: %d hosts", len(canaries.hosts))
+		completed := s.runSteps(ctx, plan.Steps, canaries.hosts, 0)
+
+		if !completed || ctx.Err() != nil || s.isStopping() {
+			result.Aborted = true
+		} else if failed := s.failedHosts(); failed > 0 {
+			log.Print(color.RedString(fmt.Sprintf("Aborting: %d of %d canary hosts failed", failed, len(canaries.hosts))))
+			result.Aborted = true
+		} else if canaries.confirm && !s.confirm(fmt.Sprintf("Canary succeeded, release the remaining %d hosts?", len(remainingHosts))) {
+			result.Aborted = true
+		}
 	}
 
-	// Finally HostLookup takes last precedence.
-	if len(allHosts) == 0 && recipe.HostLookup != "" {
-		// Otherwise do dynamic host lookup here.
-		commandSlice := strings.Split(recipe.HostLookup, " ")
-		out, err := exec.Command(commandSlice[0], commandSlice[1:]...).Output()
-		if err != nil {
-			fmt.Println("Couldn't execute command:", err.Error())
-			return
+	hostBatches := batches.split(remainingHosts)
+	for i, batch := range hostBatches {
+		if ctx.Err() != nil || s.isStopping() {
+			result.Aborted = true
+		}
+		if !result.Aborted && len(hostBatches) > 1 {
+			if i > 0 && !s.awaitNextBatch(ctx, batches, i+1, len(hostBatches)) {
+				result.Aborted = true
+			} else {
+				log.Printf("Batch %d/%d: %d hosts", i+1, len(hostBatches), len(batch))
+			}
+		}
+		if result.Aborted {
+			for _, h := range batch {
+				s.recordSkipped(h)
+			}
+			continue
 		}
 
-		allHosts = strings.Split(string(out), ",")
+		if !s.runSteps(ctx, plan.Steps, batch, i+1) {
+			result.Aborted = true
+			continue
+		}
+
+		if failed := s.failedHosts(); batches.exceeded(failed) && i < len(hostBatches)-1 {
+			log.Print(color.RedString(fmt.Sprintf("Aborting remaining batches: %d hosts failed", failed)))
+			result.Aborted = true
+		}
 	}
 
-	if len(allHosts) == 0 {
-		log.Fatalf("No host or hostlookup defined for this recipe, alternatively use the --servers flag.")
+	// Closing the queue lets the consumer drain and exit, so no goroutine outlives the session.
+	close(s.hostQueue)
+	<-s.consumerDone
+
+	result.Hosts = s.results
+	result.Skipped = s.skipped
+	result.Interrupted = s.isStopping()
+	result.Aborted = result.Aborted || len(result.Skipped) > 0
+
+	if isTimeout(ctx.Err()) {
+		log.Print(color.RedString(fmt.Sprintf("Run deadline of %s exceeded", s.timeouts.run)))
 	}
 
-	log.Print(color.GreenString(fmt.Sprintf("Recipe start: %s", recipe.Name)))
+	if !localBeforeFailed {
+		s.runLocalAfter(parent, plan, result)
+	}
+	result.Duration = time.Since(result.Started)
 
-	actualPort := modifier.FlagOverrides.Port
-	if actualPort == 0 {
-		actualPort = recipe.Overrides.Port
+	summaryColor := color.GreenString
+	if result.Failed() > 0 || result.Aborted || result.LocalFailed() {
+		summaryColor = color.RedString
 	}
-	if actualPort == 0 {
-		actualPort = 22
+	log.Print(summaryColor(fmt.Sprintf("Recipe done: %s - %d success | %d failed | %d skipped | %d total%s",
+		recipe.Name,
+		result.Succeeded(),
+		result.Failed(),
+		len(result.Skipped),
+		len(result.Hosts)+len(result.Skipped),
+		result.describeSkippedSteps())))
+
+	return result, nil
+}
+
+// runSteps runs the steps one after the other on the hosts, where a host that failed a step
+// takes no part in the steps after it. A step with hosts of its own only runs in the first
+// canary or batch. It returns false when the steps were cut short.
+func (s *Session) runSteps(ctx context.Context, steps []*PlannedStep, hosts []*PlannedHost, batch int) bool {
+	for i, step := range steps {
+		if s.pinnedDone[step] {
+			continue
+		}
+		targets := s.withoutFailedHosts(step.targets(hosts))
+
+		if len(targets) == 0 && ctx.Err() == nil && !s.isStopping() {
+			if step.Name != "" {
+				log.Printf("Step %d/%d: %s has no hosts to run on", i+1, len(steps), step.Name)
+			}
+			continue
+		}
+		if ctx.Err() != nil || s.isStopping() ||
+			(step.ConfirmBefore && !s.confirm(fmt.Sprintf("Run step %d/%d %s on %d hosts?", i+1, len(steps), step.Name, len(targets)))) {
+			for _, h := range hosts {
+				s.recordSkipped(h)
+			}
+			return false
+		}
+
+		if step.Name != "" {
+			log.Printf("Step %d/%d: %s on %d hosts", i+1, len(steps), step.Name, len(targets))
+		}
+		if len(step.Hosts) > 0 {
+			s.pinnedDone[step] = true
+		}
+		failedBefore, skippedBefore := s.failedHosts(), s.skippedSteps()
+		s.runHosts(step, targets, batch)
+		if step.Promote {
+			s.plan.promote(step, targets)
+		}
+
+		if step.Name != "" {
+			failed, skipped := s.failedHosts()-failedBefore, s.skippedSteps()-skippedBefore
+			stepColor := color.GreenString
+			if failed > 0 {
+				stepColor = color.RedString
+			}
+			log.Print(stepColor(fmt.Sprintf("Step %d/%d: %s - %d success | %d failed | %d skipped",
+				i+1, len(steps), step.Name, len(targets)-failed-skipped, failed, skipped)))
+		}
+
+		if step.SleepAfter > 0 && i < len(steps)-1 {
+			log.Printf("Sleeping %s after step %s", step.SleepAfter, step.Name)
+			select {
+			case <-time.After(step.SleepAfter):
+			case <-ctx.Done():
+			}
+		}
 	}
+	return true
+}
 
-	totalHosts := len(allHosts)
-	for _, h := range allHosts {
-		enqueueHost(h, actualPort)
+// runHosts hands the step on the hosts to the consumer and blocks until all of them are done.
+func (s *Session) runHosts(step *PlannedStep, hosts []*PlannedHost, batch int) {
+	for _, h := range hosts {
+		if s.isStopping() {
+			s.recordSkipped(h)
+			continue
+		}
+		s.enqueueHost(&hostJob{host: h, step: step, batch: batch})
 	}
+	s.hostWg.Wait()
+}
 
-	hostWg.Wait()
-	log.Print(color.GreenString(fmt.Sprintf("Recipe done: %s - %d success | %d failed | %d total",
-		recipe.Name,
-		atomic.LoadInt32(&successfullyCompleted),
-		atomic.LoadInt32(&failedCompleted),
-		totalHosts)))
+// Interrupt stops the session from starting any further hosts and forwards sig to every
+// remote command in flight, which are then left to finish on their own. Cancelling the
+// context given to Start is what force-closes the connections that remain.
+func (s *Session) Interrupt(sig ssh.Signal) {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+
+	if s.stopping == nil {
+		return
+	}
+	select {
+	case <-s.stopping:
+	default:
+		close(s.stopping)
+	}
+
+	for remote := range s.remotes {
+		remote.Signal(sig)
+	}
+	if s.stage != nil {
+		s.stage.Interrupt(sig)
+	}
+}
+
+func (s *Session) isStopping() bool {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackRemote registers a remote session so that Interrupt can signal it until untracked.
+func (s *Session) trackRemote(remote *ssh.Session) (untrack func()) {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+
+	s.remotes[remote] = struct{}{}
+	return func() {
+		s.stopMu.Lock()
+		defer s.stopMu.Unlock()
+		delete(s.remotes, remote)
+	}
 }
 
-func executeSession(recipe *recipe.BladeRecipeYaml, hostname string, commands []string) {
+// awaitNextBatch pauses and optionally confirms before the given batch, returning false to abort.
+func (s *Session) awaitNextBatch(ctx context.Context, batches *batchPolicy, number, total int) bool {
+	if batches.pause > 0 {
+		log.Printf("Pausing %s before batch %d/%d", batches.pause, number, total)
+		select {
+		case <-time.After(batches.pause):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if batches.confirm {
+		return s.confirm(fmt.Sprintf("Continue with batch %d/%d?", number, total))
+	}
+	return true
+}
+
+// confirm asks the modifier's Confirm hook, declining when no hook is available.
+func (s *Session) confirm(message string) bool {
+	if s.modifier.Confirm == nil {
+		log.Printf("%s: %s declined since no confirmation prompt is available", color.YellowString("WARN"), message)
+		return false
+	}
+	return s.modifier.Confirm(message)
+}
+
+// recordSkipped records a host that was left out. A host that already ran an earlier step
+// didn't finish the recipe rather than being skipped.
+func (s *Session) recordSkipped(host *PlannedHost) {
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+
+	hostResult, seen := s.byHost[host.key()]
+	if hostResult != nil {
+		hostResult.Interrupted = true
+	}
+	if seen {
+		return
+	}
+	s.byHost[host.key()] = nil
+	s.skipped = append(s.skipped, host.Host)
+}
+
+// withoutFailedHosts filters out the hosts that failed an earlier step.
+func (s *Session) withoutFailedHosts(hosts []*PlannedHost) []*PlannedHost {
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+
+	var remaining []*PlannedHost
+	for _, h := range hosts {
+		if hostResult := s.byHost[h.key()]; hostResult != nil && !hostResult.Succeeded() {
+			continue
+		}
+		remaining = append(remaining, h)
+	}
+	return remaining
+}
+
+func (s *Session) failedHosts() int {
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+
+	failed := 0
+	for _, h := range s.results {
+		if !h.Succeeded() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// skippedSteps returns the number of times a guard skipped a step on a host.
+func (s *Session) skippedSteps() int {
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+
+	skipped := 0
+	for _, h := range s.results {
+		skipped += len(h.SkippedSteps)
+	}
+	return skipped
+}
+
+// recordHostResult records the outcome of a step on a host, merged with the earlier steps.
+func (s *Session) recordHostResult(host *PlannedHost, stepResult *HostResult) {
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+
+	hostResult := s.byHost[host.key()]
+	if hostResult != nil {
+		hostResult.merge(stepResult)
+	} else {
+		hostResult = stepResult
+		s.byHost[host.key()] = hostResult
+		s.results = append(s.results, hostResult)
+	}
+
+	if s.failFast != nil && !hostResult.Succeeded() {
+		log.Print(color.RedString(fmt.Sprintf("Fail fast: %s failed, cancelling all hosts", hostResult.Host)))
+		s.failFast()
+		s.failFast = nil
+	}
+}
+
+func (s *Session) executeSession(ctx context.Context, job *hostJob) {
+	host, step := job.host, job.step
+	hostname := host.Host
+	hostResult := &HostResult{Host: hostname}
+	started := time.Now()
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.host)
+	defer func() {
+		cancel()
+		hostResult.Duration = time.Since(started)
+		s.recordHostResult(host, hostResult)
+	}()
+
+	commands, err := s.plan.commandsFor(step, host, job.batch)
+	var guards []*stepGuard
+	if err == nil {
+		guards, err = s.plan.guardsFor(step, host, job.batch)
+	}
+	if err != nil {
+		hostResult.PrepareError = err
+		log.Println(color.RedString(hostname) + fmt.Sprintf(" error %s", err.Error()))
+		return
+	}
+
 	sshConfig := &ssh.ClientConfig{
-		User: lookupUsernameForHost(hostname),
+		User: host.User,
 		Auth: []ssh.AuthMethod{
 			SSHAgent(),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
-	// inline user overrides sshConfig.User
-	userHost := strings.Split(hostname, "@")
-	if len(userHost) == 2 {
-		newSSHConfig := *sshConfig
-		sshConfig = &newSSHConfig
-		sshConfig.User = userHost[0]
-		hostname = userHost[1]
-	}
-
-	backoff.RetryNotify(func() error {
-		return startSSHSession(sshConfig, hostname, commands)
-	}, backoff.WithMaxTries(backoff.NewExponentialBackOff(), 3),
+	// Only failing to dial is retried at this level, failed commands are retried by their singleExecution.
+	hostResult.DialError = backoff.RetryNotify(func() error {
+		hostResult.Attempts++
+		err := s.startSSHSession(ctx, sshConfig, hostname, step, guards, commands, hostResult)
+		if err != nil && s.isStopping() {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(s.retryPolicy.dialBackOff(), ctx),
 		func(err error, dur time.Duration) {
-			// TODO: handle this better.
-			log.Println("Retry notify callback: ", err.Error())
+			log.Printf("%s: retrying in %s", color.YellowString(hostname), dur)
 		},
 	)
+
+	if isTimeout(ctx.Err()) {
+		hostResult.TimedOut = true
+		log.Print(color.RedString(hostname) + " timed out")
+	}
+
+	if step.Register != "" && hostResult.Succeeded() && len(hostResult.SkippedSteps) == 0 {
+		if err := s.plan.register(step, host, hostResult); err != nil {
+			hostResult.CaptureError = fmt.Errorf("Step %s: %s", step.Name, err.Error())
+			log.Println(color.RedString(hostname) + fmt.Sprintf(" error %s", hostResult.CaptureError.Error()))
+		}
+	}
 }
 
-func startSSHSession(sshConfig *ssh.ClientConfig, hostname string, commands []string) error {
+func (s *Session) startSSHSession(ctx context.Context, sshConfig *ssh.ClientConfig, hostname string, step *PlannedStep, guards []*stepGuard, commands []*PlannedCommand, hostResult *HostResult) error {
 	var finalError error
 	defer func() {
 		if finalError != nil {
@@ -163,56 +543,86 @@ func startSSHSession(sshConfig *ssh.ClientConfig, hostname string, commands []st
 		}
 	}()
 
-	client, err := ssh.Dial("tcp", hostname, sshConfig)
+	env := step.remoteEnv(s.plan.run)
+	client, err := dialContext(ctx, hostname, sshConfig, s.timeouts.dial)
 	if err != nil {
 		finalError = fmt.Errorf("Failed to dial remote host: %s", err.Error())
 		return finalError
 	}
 
-	// Since we can run multiple commands, we need to keep track of intermediate failures
-	// and log accordingly or do some type of aggregate report.
-	// Commands within a single session are executed in serial by design.
+	// Once the context is done the connection is force-closed, whatever is in flight on it.
+	hostDone := make(chan struct{})
+	defer close(hostDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-hostDone:
+		}
+		client.Close()
+	}()
+
+	// The guards decide whether the step runs on this host at all.
+	skip, err := s.checkGuards(ctx, client, hostname, guards, env)
+	if err != nil {
+		guardResult := &CommandResult{Step: step.Name}
+		guardResult.recordError(err)
+		hostResult.Commands = append(hostResult.Commands, guardResult)
+		log.Println(color.RedString(hostname) + fmt.Sprintf(" error %s", err.Error()))
+		return nil
+	}
+	if skip != "" {
+		log.Printf("%s step %s skipped: %s", color.CyanString(hostname), step.Name, skip)
+		hostResult.SkippedSteps = append(hostResult.SkippedSteps, step.Name)
+		return nil
+	}
+
+	// Commands within a single session are executed in serial by design and each
+	// outcome is kept so the session can report accurately on failures.
 	for i, cmd := range commands {
-		se := newSingleExecution(client, hostname, cmd, i+1)
-		se.execute()
+		if ctx.Err() != nil || s.isStopping() {
+			// The remaining commands never ran so this host can't count as a success.
+			hostResult.Interrupted = true
+			break
+		}
+		se := newSingleExecution(s, client, hostname, cmd.Command, i+1, env)
+		se.capture = step.Register != ""
+		cmdResult := se.execute(ctx)
+		cmdResult.Step = step.Name
+		hostResult.Commands = append(hostResult.Commands, cmdResult)
+
+		if cmdResult.Succeeded() {
+			continue
+		}
+		switch cmd.OnFailure {
+		case recipe.OnFailureIgnore:
+			cmdResult.Ignored = true
+		case recipe.OnFailureContinue:
+		default:
+			if i < len(commands)-1 {
+				log.Print(color.RedString(hostname) + fmt.Sprintf(" command %d failed, skipping the remaining %d commands", i+1, len(commands)-i-1))
+			}
+			return nil
+		}
 	}
 
-	// Technically this is only successful when errors didn't occur above.
-	atomic.AddInt32(&successfullyCompleted, 1)
 	return nil
 }
 
 var argSubstitutions = regexp.MustCompile(`\${.*?}`)
 
-func applyRecipeArgs(args recipe.BladeRecipeArguments, commands []string) ([]string, error) {
-	if len(args) == 0 {
-		return commands, nil
-	}
-
+func applyRecipeArgs(args recipe.BladeRecipeArguments, commands []string, scoped map[string]ast.Variable) ([]string, error) {
 	identifiedSubs := 0
 	unusedSubs := 0
 
-	// Build hil eval context.
-	evalContext := &hil.EvalConfig{
-		GlobalScope: &ast.BasicScope{
-			VarMap: make(map[string]ast.Variable),
-			// TODO: add FuncMap to enhance the usability.
-		},
+	// Bind all supplied arg/flags values, as their own types, to evalContext.
+	vars, err := args.Variables()
+	if err != nil {
+		return nil, err
 	}
-
-	// Bind all supplied arg/flags values to evalContext.
-	for _, arg := range args {
-		appliedFlagValue := arg.FlagValue()
-		// If user supplied a flag, use it otherwise use the arg provided in the recipe.
-		variableValue := arg.Value
-		if appliedFlagValue != "" {
-			variableValue = appliedFlagValue
-		}
-		evalContext.GlobalScope.VarMap[arg.Name()] = ast.Variable{
-			Type:  ast.TypeString,
-			Value: variableValue,
-		}
+	for name, v := range scoped {
+		vars[name] = v
 	}
+	evalContext := recipe.NewEvalConfig(vars)
 
 	var appliedSSHCommands []string
 	for _, cmd := range commands {
@@ -222,16 +632,29 @@ func applyRecipeArgs(args recipe.BladeRecipeArguments, commands []string) ([]str
 		// Parse the HIL expression.
 		tree, err := hil.Parse(cmd)
 		if err != nil {
-			log.Fatalf("Failed to parse HIL expression for command: %q with err: %s", cmd, err.Error())
+			return nil, fmt.Errorf("Failed to parse HIL expression for command: %q with err: %s", cmd, err.Error())
 		}
 
+		// Every value is shell quoted unless it was asked to be raw.
+		tree = recipe.QuoteInterpolations(tree, args)
+
 		// Perform HIL evaluation against bound variables to generate the new command.
 		result, err := hil.Eval(tree, evalContext)
 		if err != nil {
-			log.Fatalf("Failed to evaluate HIL expression tree against bound arguments for command: %q with err: %s", cmd, err.Error())
+			return nil, fmt.Errorf("Failed to evaluate HIL expression tree against bound arguments for command: %q with err: %s", cmd, err.Error())
 		}
 
-		newCmd := result.Value.(string)
+		var newCmd string
+		switch result.Type {
+		case hil.TypeString, hil.TypeBool:
+			newCmd = fmt.Sprint(result.Value)
+		case hil.TypeUnknown:
+			// It uses what steps register, which is only known once they ran.
+			appliedSSHCommands = append(appliedSSHCommands, cmd)
+			continue
+		default:
+			return nil, fmt.Errorf("Command %q must evaluate to a string, not a %s", cmd, result.Type)
+		}
 		// Get a count of any non-applied substitutions, consider this a bad thing.
 		unusedSubs += len(argSubstitutions.FindAllString(newCmd, -1))
 		appliedSSHCommands = append(appliedSSHCommands, newCmd)
@@ -21,6 +21,8 @@ SOFTWARE.
 
 package ssh
 
+import "time"
+
 // NewSessionModifier creates and returns a new SessionModifier.
 func NewSessionModifier() *SessionModifier {
 	return &SessionModifier{}
@@ -34,5 +36,35 @@ type SessionModifier struct {
 		Concurrency int
 		Hosts       []string
 		Port        int
+		User        string
+
+		// Retry counts are pointers since zero retries is a valid override.
+		Retries        *int
+		DialRetries    *int
+		CommandRetries *int
+
+		BatchSize            string
+		BatchPause           time.Duration
+		BatchConfirm         bool
+		MaxFailures          *int
+		MaxFailurePercentage *int
+
+		CanaryCount   int
+		CanaryHosts   []string
+		CanaryConfirm bool
+
+		DialTimeout    time.Duration
+		CommandTimeout time.Duration
+		HostTimeout    time.Duration
+		RunTimeout     time.Duration
+
+		FailFast bool
 	}
+
+	// Confirm is asked before continuing at confirmation points such as between batches.
+	// When nil every confirmation is declined so unattended sessions never proceed blindly.
+	Confirm func(message string) bool
+	// Approve is shown the plan of a session before any host is dialed, returning false
+	// stops the session with ErrNotApproved. When nil every plan is approved.
+	Approve func(plan *Plan) bool
 }
@@ -0,0 +1,198 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionResult is the outcome of a completed Session.
+type SessionResult struct {
+	Recipe   string
+	Started  time.Time
+	Duration time.Duration
+
+	// Hosts holds one entry per host that was enqueued, in completion order.
+	Hosts []*HostResult
+
+	// Aborted is set when the session stopped before every host was run.
+	Aborted bool
+	// Interrupted is set when the session was stopped by Interrupt.
+	Interrupted bool
+	// Skipped holds the hosts that were never run because the session was aborted.
+	Skipped []string
+
+	// Stages holds the result of every stage that ran of a composed recipe, whose Hosts
+	// and Skipped are then the ones of all of its stages together.
+	Stages []*SessionResult
+	// SkippedStages holds the recipes of the stages that never ran.
+	SkippedStages []string
+
+	// LocalBefore and LocalAfter hold the results of the commands that ran on this machine.
+	LocalBefore []*LocalResult
+	LocalAfter  []*LocalResult
+}
+
+// SkippedSteps returns the number of times a guard skipped a step on a host.
+func (r *SessionResult) SkippedSteps() int {
+	skipped := 0
+	for _, h := range r.Hosts {
+		skipped += len(h.SkippedSteps)
+	}
+	return skipped
+}
+
+// describeSkippedSteps completes the summary of a run with the steps guards skipped, if any.
+func (r *SessionResult) describeSkippedSteps() string {
+	if skipped := r.SkippedSteps(); skipped > 0 {
+		return fmt.Sprintf(" | %d steps skipped", skipped)
+	}
+	return ""
+}
+
+// LocalFailed reports whether a local_before or local_after command failed.
+func (r *SessionResult) LocalFailed() bool {
+	for _, results := range [][]*LocalResult{r.LocalBefore, r.LocalAfter} {
+		for _, l := range results {
+			if !l.Succeeded() && !l.Ignored {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Succeeded returns the number of hosts where every command succeeded.
+func (r *SessionResult) Succeeded() int {
+	count := 0
+	for _, h := range r.Hosts {
+		if h.Succeeded() {
+			count++
+		}
+	}
+	return count
+}
+
+// Failed returns the number of hosts that couldn't be dialed or had a failing command.
+func (r *SessionResult) Failed() int {
+	return len(r.Hosts) - r.Succeeded()
+}
+
+// HostResult is the outcome of running a recipe against a single host.
+type HostResult struct {
+	Host     string
+	Attempts int
+	Duration time.Duration
+
+	// DialError is set when no ssh connection could be established after all attempts.
+	DialError error
+	// PrepareError is set when the commands couldn't be evaluated for this host.
+	PrepareError error
+	// CaptureError is set when the stdout of a step couldn't be registered on this host.
+	CaptureError error
+	// TimedOut is set when the host timeout or the run deadline expired on this host.
+	TimedOut bool
+	// Interrupted is set when the session stopped before all commands ran on this host.
+	Interrupted bool
+
+	Commands []*CommandResult
+	// SkippedSteps holds the names of the steps a guard skipped on this host.
+	SkippedSteps []string
+}
+
+// Succeeded reports whether the host was reached and all of its commands succeeded.
+func (h *HostResult) Succeeded() bool {
+	if h.DialError != nil || h.PrepareError != nil || h.CaptureError != nil || h.TimedOut || h.Interrupted {
+		return false
+	}
+	for _, c := range h.Commands {
+		if !c.Succeeded() && !c.Ignored {
+			return false
+		}
+	}
+	return true
+}
+
+// merge folds the outcome of a later step on the same host into h.
+func (h *HostResult) merge(step *HostResult) {
+	h.Attempts += step.Attempts
+	h.Duration += step.Duration
+	if step.DialError != nil {
+		h.DialError = step.DialError
+	}
+	if step.PrepareError != nil {
+		h.PrepareError = step.PrepareError
+	}
+	if step.CaptureError != nil {
+		h.CaptureError = step.CaptureError
+	}
+	h.TimedOut = h.TimedOut || step.TimedOut
+	h.Interrupted = h.Interrupted || step.Interrupted
+	h.Commands = append(h.Commands, step.Commands...)
+	h.SkippedSteps = append(h.SkippedSteps, step.SkippedSteps...)
+}
+
+// CommandResult is the outcome of a single remote command on a single host.
+type CommandResult struct {
+	Command string
+	// Step is the name of the step the command belongs to, empty for a recipe's exec list.
+	Step     string
+	Index    int
+	Attempts int
+	Duration time.Duration
+
+	// ExitStatus is the remote exit status, or -1 when the command never reported one.
+	ExitStatus int
+	// Signal is the name of the remote signal that terminated the command if any.
+	Signal string
+	// Err is the error of the final attempt, nil on success.
+	Err error
+	// TimedOut is set when the final attempt was stopped by a timeout.
+	TimedOut bool
+	// Ignored is set when the command failed but its on_failure policy is ignore.
+	Ignored bool
+	// Stdout holds what the final attempt printed when its step registers it.
+	Stdout string
+}
+
+// Succeeded reports whether the final attempt of the command succeeded.
+func (c *CommandResult) Succeeded() bool {
+	return c.Err == nil
+}
+
+// recordError captures the exit status and signal carried by a session.Run error.
+func (c *CommandResult) recordError(err error) {
+	c.Err = err
+	c.ExitStatus = -1
+	c.Signal = ""
+
+	switch e := err.(type) {
+	case nil:
+		c.ExitStatus = 0
+	case *ssh.ExitError:
+		c.ExitStatus = e.ExitStatus()
+		c.Signal = e.Signal()
+	}
+}
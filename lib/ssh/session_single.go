@@ -22,7 +22,10 @@ SOFTWARE.
 package ssh
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 	"time"
@@ -32,32 +35,52 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-func newSingleExecution(client *ssh.Client, hostname, command string, index int) *singleExecution {
+func newSingleExecution(owner *Session, client *ssh.Client, hostname, command string, index int, env *remoteEnv) *singleExecution {
 	return &singleExecution{
+		env:          env,
 		attempt:      1,
+		owner:        owner,
 		client:       client,
 		command:      command,
 		commandIndex: index,
 		hostname:     hostname,
+		policy:       owner.retryPolicy,
+		timeout:      owner.timeouts.command,
 	}
 }
 
 type singleExecution struct {
-	attempt int
-	success int
+	attempt  int
+	success  int
+	policy   *RetryPolicy
+	timeout  time.Duration
+	timedOut bool
+
+	owner *Session
 
 	client *ssh.Client
 
 	command      string
 	commandIndex int
+	env          *remoteEnv
+
+	// capture keeps the stdout of the latest attempt for the step to register.
+	capture bool
+	stdout  bytes.Buffer
 
 	hostname string
 }
 
-func (se *singleExecution) execute() {
-	backoff.RetryNotify(func() error {
-		return se.do()
-	}, backoff.WithMaxTries(backoff.NewExponentialBackOff(), 2),
+func (se *singleExecution) execute(ctx context.Context) *CommandResult {
+	result := &CommandResult{
+		Command: se.command,
+		Index:   se.commandIndex,
+	}
+	started := time.Now()
+
+	err := backoff.RetryNotify(func() error {
+		return se.do(ctx)
+	}, backoff.WithContext(se.policy.commandBackOff(), ctx),
 		func(err error, dur time.Duration) {
 			// TODO: handle this better.
 			//log.Println("Retry notify single command callback: ", err.Error())
@@ -65,10 +88,19 @@ func (se *singleExecution) execute() {
 			se.attempt++
 		},
 	)
+
+	result.Attempts = se.attempt
+	result.Duration = time.Since(started)
+	result.recordError(err)
+	result.TimedOut = se.timedOut
+	if se.capture {
+		result.Stdout = se.stdout.String()
+	}
+	return result
 }
 
 // do - the rule is one command can only ever occur per session.
-func (se *singleExecution) do() error {
+func (se *singleExecution) do(ctx context.Context) error {
 	var finalError error
 	defer func() {
 		if finalError != nil {
@@ -85,6 +117,10 @@ func (se *singleExecution) do() error {
 	}
 	defer session.Close()
 
+	// Register the session so that an interrupt can be forwarded to the remote command.
+	untrack := se.owner.trackRemote(session)
+	defer untrack()
+
 	out, err := session.StdoutPipe()
 	if err != nil {
 		finalError = fmt.Errorf("Couldn't create pipe to Stdout for session: %s", err.Error())
@@ -110,15 +146,58 @@ func (se *singleExecution) do() error {
 	}()
 
 	// Consume session Stdout, Stderr pipe async.
-	go consumeReaderPipes(&wg, currentHost, out, false, 0)
+	var stdout io.Reader = out
+	se.stdout.Reset()
+	if se.capture {
+		stdout = io.TeeReader(out, &se.stdout)
+	}
+	go consumeReaderPipes(&wg, currentHost, stdout, false, 0)
 	go consumeReaderPipes(&wg, currentHost, errOut, true, se.attempt)
 
 	// Once a Session is created, you can only ever execute a single command.
-	if err := session.Run(se.command); err != nil {
-		// TODO: use this line for more verbose error logging since Stderr is also displayed.
-		//sessionLogger.Print(color.RedString(currentHost+":") + fmt.Sprintf(" Failed to run the %s command: `%s` - %s", humanize.Ordinal(index), command, err.Error()))
+	if err := session.Start(se.env.prepare(session, se.hostname, se.command)); err != nil {
 		return err
 	}
+
+	// The timeout applies per attempt, so a retry gets the full timeout again.
+	cmdCtx, cancel := withTimeout(ctx, se.timeout)
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- session.Wait()
+	}()
+
+	select {
+	case err := <-runErr:
+		se.timedOut = false
+		// A command that was interrupted must not be started all over again.
+		if err != nil && se.owner.isStopping() {
+			return backoff.Permanent(err)
+		}
+		if err != nil {
+			// TODO: use this line for more verbose error logging since Stderr is also displayed.
+			//sessionLogger.Print(color.RedString(currentHost+":") + fmt.Sprintf(" Failed to run the %s command: `%s` - %s", humanize.Ordinal(index), command, err.Error()))
+			return err
+		}
+	case <-cmdCtx.Done():
+		// Ask the remote command to stop, then tear down the session so Wait returns.
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+		<-runErr
+
+		se.timedOut = isTimeout(cmdCtx.Err())
+		if se.timedOut {
+			finalError = fmt.Errorf("Command timed out: `%s`", se.command)
+		} else {
+			finalError = fmt.Errorf("Command cancelled: `%s`", se.command)
+		}
+		// Only the command timeout is worth retrying, the host itself is out of time otherwise.
+		if ctx.Err() != nil {
+			return backoff.Permanent(finalError)
+		}
+		return finalError
+	}
 	se.success++
 	return nil
 }
@@ -0,0 +1,105 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/hashicorp/hil/ast"
+)
+
+// unreachableRecipe loads a recipe whose only host refuses connections, so that a session
+// runs to completion right away without retrying.
+func unreachableRecipe(t *testing.T) *recipe.BladeRecipeYaml {
+	dir, err := ioutil.TempDir("", "blade-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "unreachable.blade.yaml")
+	yaml := "hosts: [\"127.0.0.1:1\"]\noverrides:\n  user: blade\nresilience:\n  retries: 0\nexec:\n  - echo ${run.id}\n"
+	if err := ioutil.WriteFile(file, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := recipe.LoadRecipeYaml(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Name = "unreachable"
+	return rec
+}
+
+func TestStartIsARunOfItsOwn(t *testing.T) {
+	session := NewSession(unreachableRecipe(t), nil)
+
+	if _, err := session.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	first := session.run.id
+
+	if _, err := session.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if session.run.id == first {
+		t.Errorf("the second run has the id %s of the first one", first)
+	}
+}
+
+func TestStartForgetsPromotedValues(t *testing.T) {
+	session := NewSession(unreachableRecipe(t), nil)
+
+	if _, err := session.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	session.run.promote("version", map[string]ast.Variable{
+		recipe.StepsPrefix + "version.stdout": {Type: ast.TypeString, Value: "1.2"},
+	})
+
+	if _, err := session.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if promoted := session.run.promotedVars(); len(promoted) > 0 {
+		t.Errorf("the second run starts out with the values promoted by the first one: %v", promoted)
+	}
+}
+
+func TestPlanRecipeBuiltInCode(t *testing.T) {
+	rec := &recipe.BladeRecipeYaml{
+		Name:  "handmade",
+		Hosts: []string{"127.0.0.1:1"},
+		Exec:  []*recipe.BladeRecipeCommand{{Run: "uptime"}},
+	}
+
+	plan, err := NewSession(rec, nil).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Hosts) != 1 || plan.Hosts[0].Host != "127.0.0.1:1" {
+		t.Errorf("planned hosts %v, want 127.0.0.1:1", plan.Hosts)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// planStages plans every stage of a composed recipe up front, so that a broken stage
+// stops the run before any host of an earlier stage was touched.
+func (s *Session) planStages() (*Plan, error) {
+	if len(s.recipe.Exec) > 0 || len(s.recipe.Steps) > 0 {
+		return nil, errors.New("A recipe with stages can't have exec commands or steps of its own")
+	}
+
+	timeouts, err := resolveTimeoutPolicy(s.recipe.Timeouts, s.modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.run == nil {
+		s.run = newRunInfo()
+	}
+	plan := &Plan{Recipe: s.recipe.Name, Interaction: s.recipe.Interaction, timeouts: timeouts, recipe: s.recipe, run: s.run}
+	// Every stage can use what the stages before it promoted.
+	plan.registers = s.runRegisters()
+	for i, stage := range s.recipe.Stages {
+		child, err := stage.Apply(s.recipe)
+		if err != nil {
+			return nil, err
+		}
+		// Every stage is part of the same run.
+		stageSession := NewSession(child, s.modifier)
+		stageSession.run = s.run
+		stageSession.registers = plan.registers
+		stagePlan, err := stageSession.Plan()
+		if err != nil {
+			return nil, fmt.Errorf("Stage %d (%s): %s", i+1, stage.Recipe, err.Error())
+		}
+		stagePlan.recipe = child
+		plan.Stages = append(plan.Stages, stagePlan)
+		plan.registers = stagePlan.registers
+	}
+	if err := plan.planLocal(s.runRegisters()); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// startStages runs the stages of a composed recipe one after the other, each as a session
+// of its own. A stage only starts when every host of the stage before it succeeded, and runs
+// the plan made for it up front rather than being planned and approved again.
+func (s *Session) startStages(ctx context.Context, plan *Plan) (*SessionResult, error) {
+	result := &SessionResult{
+		Recipe:  s.recipe.Name,
+		Started: time.Now(),
+	}
+	log.Print(color.GreenString(fmt.Sprintf("Recipe start: %s - %d stages", s.recipe.Name, len(plan.Stages))))
+
+	// The run deadline of the composed recipe covers all of its stages.
+	plan.captures = newCaptures()
+	parent := ctx
+	ctx, cancel := withTimeout(ctx, plan.timeouts.run)
+	defer cancel()
+
+	localBeforeFailed := !s.runLocalBefore(ctx, plan, result)
+
+	for i, stagePlan := range plan.Stages {
+		stage := NewSession(stagePlan.recipe, s.modifier)
+		stage.run = s.run
+		stage.reset()
+		// The stage is published before stopping is checked, so an Interrupt either reaches
+		// it or keeps it from starting.
+		if s.setStage(stage) || result.Aborted || ctx.Err() != nil {
+			s.setStage(nil)
+			log.Printf("Stage %d/%d: %s skipped", i+1, len(plan.Stages), stagePlan.Recipe)
+			result.SkippedStages = append(result.SkippedStages, stagePlan.Recipe)
+			result.Aborted = true
+			continue
+		}
+
+		log.Print(color.GreenString(fmt.Sprintf("Stage %d/%d: %s", i+1, len(plan.Stages), stagePlan.Recipe)))
+		stageResult, err := stage.startPlan(ctx, stagePlan)
+		s.setStage(nil)
+		if err != nil {
+			return nil, fmt.Errorf("Stage %d (%s): %s", i+1, stagePlan.Recipe, err.Error())
+		}
+
+		result.Stages = append(result.Stages, stageResult)
+		result.Hosts = append(result.Hosts, stageResult.Hosts...)
+		result.Skipped = append(result.Skipped, stageResult.Skipped...)
+		result.Interrupted = result.Interrupted || stageResult.Interrupted
+		if stageResult.Failed() > 0 || stageResult.Aborted {
+			result.Aborted = true
+		}
+	}
+	if !localBeforeFailed {
+		s.runLocalAfter(parent, plan, result)
+	}
+	result.Duration = time.Since(result.Started)
+
+	for i, stageResult := range result.Stages {
+		log.Printf("Stage %d: %s - %d success | %d failed | %d skipped",
+			i+1, stageResult.Recipe, stageResult.Succeeded(), stageResult.Failed(), len(stageResult.Skipped))
+	}
+
+	summaryColor := color.GreenString
+	if result.Failed() > 0 || result.Aborted || result.LocalFailed() {
+		summaryColor = color.RedString
+	}
+	log.Print(summaryColor(fmt.Sprintf("Recipe done: %s - %d/%d stages | %d success | %d failed | %d skipped | %d total%s",
+		s.recipe.Name,
+		len(result.Stages),
+		len(plan.Stages),
+		result.Succeeded(),
+		result.Failed(),
+		len(result.Skipped),
+		len(result.Hosts)+len(result.Skipped),
+		result.describeSkippedSteps())))
+
+	return result, nil
+}
+
+// setStage records the session of the stage in flight so that Interrupt can reach it, and
+// reports whether the session is already stopping.
+func (s *Session) setStage(stage *Session) bool {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	s.stage = stage
+
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
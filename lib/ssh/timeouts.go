@@ -0,0 +1,144 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultDialTimeout = 30 * time.Second
+
+// timeoutPolicy holds the timeouts of a session, zero means no timeout.
+type timeoutPolicy struct {
+	dial    time.Duration
+	command time.Duration
+	host    time.Duration
+	run     time.Duration
+}
+
+// resolveTimeoutPolicy applies the precedence: flag, then recipe, then default.
+func resolveTimeoutPolicy(timeouts *recipe.BladeRecipeTimeouts, modifier *SessionModifier) (*timeoutPolicy, error) {
+	if timeouts == nil {
+		timeouts = &recipe.BladeRecipeTimeouts{}
+	}
+	flags := modifier.FlagOverrides
+
+	policy := &timeoutPolicy{dial: defaultDialTimeout}
+
+	settings := []struct {
+		name   string
+		recipe string
+		flag   time.Duration
+		target *time.Duration
+	}{
+		{"dial", timeouts.Dial, flags.DialTimeout, &policy.dial},
+		{"command", timeouts.Command, flags.CommandTimeout, &policy.command},
+		{"host", timeouts.Host, flags.HostTimeout, &policy.host},
+		{"run", timeouts.Run, flags.RunTimeout, &policy.run},
+	}
+
+	for _, setting := range settings {
+		if setting.recipe != "" {
+			d, err := time.ParseDuration(setting.recipe)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("Invalid %s timeout %q, expected a duration like 5m", setting.name, setting.recipe)
+			}
+			*setting.target = d
+		}
+		if setting.flag > 0 {
+			*setting.target = setting.flag
+		}
+	}
+
+	return policy, nil
+}
+
+// String describes the timeouts in a single line, handy for logs and plans.
+func (p *timeoutPolicy) String() string {
+	describe := func(d time.Duration) string {
+		if d == 0 {
+			return "none"
+		}
+		return d.String()
+	}
+	return fmt.Sprintf("dial %s, command %s, host %s, run %s",
+		describe(p.dial), describe(p.command), describe(p.host), describe(p.run))
+}
+
+// withTimeout derives a context that expires after d, or one that is only cancelled when d is zero.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// dialContext is ssh.Dial bounded by a timeout that also covers the ssh handshake and
+// abandoned as soon as ctx is done.
+func dialContext(ctx context.Context, addr string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	dialCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the connection is the only way to interrupt a handshake in flight.
+	handshakeDone := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-dialCtx.Done():
+			conn.Close()
+		case <-handshakeDone:
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(handshakeDone)
+	<-watcherDone
+
+	// The watcher may have closed the connection just as the handshake finished.
+	if dialCtx.Err() != nil {
+		conn.Close()
+		return nil, dialCtx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// isTimeout reports whether err stems from a context deadline.
+func isTimeout(err error) bool {
+	return err == context.DeadlineExceeded
+}
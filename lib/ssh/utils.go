@@ -23,6 +23,7 @@ package ssh
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -33,8 +34,6 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/deckarep/blade/lib/recipe"
-
 	humanize "github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"github.com/gobwas/glob"
@@ -61,27 +60,30 @@ func init() {
 	hostGlobList = createHostGlobList()
 }
 
-func lookupUsernameForHost(host string) string {
-	// TODO: clean this up but we have to strip the :22 port.
-	actualHost := strings.Replace(host, ":22", "", -1)
+// lookupUsernameForHost resolves the user of a host:port and where that user came from.
+func lookupUsernameForHost(host string) (string, Source) {
+	actualHost := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		actualHost = h
+	}
 
 	// Precedence of username returned:
-	// 	1. First host glob match.
+	// 	1. First host glob match with a User.
 	// 	2. Full * (wildcard) for host glob.
 	// 	3. HOME user if able to get.
 	// 	4. default user to fallback on.
 	for _, hostGlob := range hostGlobList {
-		if hostGlob.glob.Match(actualHost) {
-			return hostGlob.entry.User
+		if hostGlob.entry.User != "" && hostGlob.glob.Match(actualHost) {
+			return hostGlob.entry.User, SourceSSHConfig
 		}
 	}
 
 	user, err := user.Current()
 	if err != nil {
 		log.Printf("%s: Couldn't get username for local user\n", color.YellowString("WARN"))
-		return defaultUnmatchedUser
+		return defaultUnmatchedUser, SourceDefault
 	}
-	return user.Username
+	return user.Username, SourceLocalUser
 }
 
 func createHostGlobList() []*hostGlobItem {
@@ -130,23 +132,43 @@ func parseSSHConfig() ([]*sshconfig.SSHHost, error) {
 	return hosts, nil
 }
 
-func consumeAndLimitConcurrency(recipe *recipe.BladeRecipeYaml, commands []string, concurrency int) {
-	// Limit the amount of concurrent ssh sessions.
-	concurrencySem := make(chan int, concurrency)
+func (s *Session) consumeAndLimitConcurrency(ctx context.Context) {
+	defer close(s.consumerDone)
 
-	for host := range hostQueue {
-		concurrencySem <- 1
-		go func(h string) {
+	// Limit the amount of concurrent ssh sessions, every step has a limit of its own.
+	var (
+		currentStep    *PlannedStep
+		concurrencySem chan int
+	)
+
+	for job := range s.hostQueue {
+		if job.step != currentStep {
+			currentStep = job.step
+			concurrencySem = make(chan int, currentStep.Concurrency)
+		}
+		sem := concurrencySem
+
+		sem <- 1
+		// Hosts that were waiting on the semaphore when the session was interrupted, or when
+		// the run deadline passed, never start.
+		if s.isStopping() || ctx.Err() != nil {
+			s.recordSkipped(job.host)
+			<-sem
+			s.hostWg.Done()
+			continue
+		}
+		go func(j *hostJob) {
 			defer func() {
-				<-concurrencySem
-				hostWg.Done()
+				<-sem
+				s.hostWg.Done()
 			}()
-			executeSession(recipe, h, commands)
-		}(host)
+			s.executeSession(ctx, j)
+		}(job)
 	}
 }
 
-func enqueueHost(host string, port int) {
+// normalizeHost trims the host and adds the port unless it has one.
+func normalizeHost(host string, port int) (string, error) {
 	host = strings.TrimSpace(host)
 
 	// If it doesn't contain the port; add it.
@@ -154,16 +176,17 @@ func enqueueHost(host string, port int) {
 		host = fmt.Sprintf("%s:%d", host, port)
 	}
 
-	// Ignore what can't be parsed as host:port.
-	_, _, err := net.SplitHostPort(host)
-	if err != nil {
-		log.Printf("Couldn't parse: %s", host)
-		return
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return "", err
 	}
+	return host, nil
+}
 
-	// Finally, enqueue it up for processing.
-	hostQueue <- host
-	hostWg.Add(1)
+func (s *Session) enqueueHost(job *hostJob) {
+	// The wait group must be bumped before the host is handed off, otherwise a fast
+	// consumer could call Done first.
+	s.hostWg.Add(1)
+	s.hostQueue <- job
 }
 
 func consumeReaderPipes(wg *sync.WaitGroup, host string, rdr io.Reader, isStdErr bool, attempt int) {
@@ -0,0 +1,133 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+The MIT License (MIT)
+Copyright (c) 2017 Ralph Caraveo (deckarep@gmail.com)
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/deckarep/blade/lib/recipe"
+	"github.com/hashicorp/hil/ast"
+)
+
+// runInfo identifies a single run of a recipe, which is shared by all of its stages.
+type runInfo struct {
+	id      string
+	started time.Time
+
+	// promoted holds the variables steps promoted to the run, by the name they registered.
+	mu            sync.Mutex
+	promoted      map[string]ast.Variable
+	promotedSteps map[string]bool
+	// envRejected holds the hosts that rejected Setenv.
+	envRejected map[string]bool
+}
+
+func newRunInfo() *runInfo {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Unique enough to tell runs apart when there's no randomness to be had.
+		return &runInfo{id: strconv.FormatInt(time.Now().UnixNano(), 16), started: time.Now()}
+	}
+	return &runInfo{id: hex.EncodeToString(b), started: time.Now()}
+}
+
+// hostVars returns the variables scoped to a host running a step in the given batch, where
+// canaries run as batch 0. The index and count of a host are those among the hosts of the
+// step when it has hosts of its own.
+func (p *Plan) hostVars(step *PlannedStep, host *PlannedHost, batch int) map[string]ast.Variable {
+	hosts := p.Hosts
+	if len(step.Hosts) > 0 {
+		hosts = step.Hosts
+	}
+	index := 0
+	for i, h := range hosts {
+		if h.key() == host.key() {
+			index = i
+			break
+		}
+	}
+
+	name, portValue, err := net.SplitHostPort(host.Host)
+	if err != nil {
+		name, portValue = host.Host, strconv.Itoa(p.Port)
+	}
+	port, _ := strconv.Atoi(portValue)
+
+	vars := map[string]ast.Variable{
+		"host.name":    {Type: ast.TypeString, Value: name},
+		"host.port":    {Type: ast.TypeInt, Value: port},
+		"host.user":    {Type: ast.TypeString, Value: host.User},
+		"host.index":   {Type: ast.TypeInt, Value: index},
+		"host.count":   {Type: ast.TypeInt, Value: len(hosts)},
+		"batch.number": {Type: ast.TypeInt, Value: batch},
+	}
+	for k, v := range p.runVars() {
+		vars[k] = v
+	}
+	// Every inventory variable is set for every host, empty where the host doesn't have it.
+	for _, inventory := range p.recipe.Inventory {
+		for k := range inventory {
+			vars[recipe.InventoryPrefix+k] = ast.Variable{Type: ast.TypeString, Value: ""}
+		}
+	}
+	for k, v := range p.recipe.InventoryFor(host.Host) {
+		vars[recipe.InventoryPrefix+k] = ast.Variable{Type: ast.TypeString, Value: v}
+	}
+	return vars
+}
+
+// runVars returns the variables of the run, which are the same everywhere.
+func (p *Plan) runVars() map[string]ast.Variable {
+	return map[string]ast.Variable{
+		"run.id":         {Type: ast.TypeString, Value: p.run.id},
+		"run.started_at": {Type: ast.TypeString, Value: p.run.started.UTC().Format(time.RFC3339)},
+	}
+}
+
+// batchOf returns the batch the host runs in as planned, canaries being batch 0.
+func (p *Plan) batchOf(host *PlannedHost) int {
+	for _, h := range p.canary.hosts {
+		if h.key() == host.key() {
+			return 0
+		}
+	}
+	for i, batch := range p.batches.split(p.remaining) {
+		for _, h := range batch {
+			if h.key() == host.key() {
+				return i + 1
+			}
+		}
+	}
+	return 1
+}
+
+// commandsFor evaluates the commands of the step for a single host.
+func (p *Plan) commandsFor(step *PlannedStep, host *PlannedHost, batch int) ([]*PlannedCommand, error) {
+	vars := p.hostVars(step, host, batch)
+	p.capturedVars(vars, host, step.exec)
+	return prepareCommands(p.recipe.Args, step.exec, vars)
+}